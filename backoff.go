@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// BackoffConfig describes the delay between readiness attempts while waiting
+// for a target: the delay starts at InitialDelay and is multiplied by
+// Multiplier after every attempt, up to MaxDelay. Jitter randomly spreads each
+// delay by up to that fraction in either direction, so waiting clients and
+// restarted mop instances don't probe in lockstep.
+type BackoffConfig struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+}
+
+// Delay returns the delay to wait after the given attempt, counting from 1.
+func (b BackoffConfig) Delay(attempt int) time.Duration {
+	delay := float64(b.InitialDelay) * math.Pow(b.Multiplier, float64(attempt-1))
+	delay = min(delay, float64(b.MaxDelay))
+
+	if b.Jitter > 0 {
+		delay *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := BackoffConfig{
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+	}
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	for i, want := range expected {
+		if got := b.Delay(i + 1); got != want {
+			t.Errorf("Attempt %d: expected delay %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestBackoffDelayHugeAttempt(t *testing.T) {
+	b := BackoffConfig{InitialDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2}
+	if got := b.Delay(10000); got != time.Minute {
+		t.Errorf("Expected delay to be capped at %v, got %v", time.Minute, got)
+	}
+}
+
+func TestBackoffDelayJitter(t *testing.T) {
+	b := BackoffConfig{
+		InitialDelay: 4 * time.Second,
+		MaxDelay:     4 * time.Second,
+		Multiplier:   1,
+		Jitter:       0.25,
+	}
+
+	for range 100 {
+		got := b.Delay(1)
+		if got < 3*time.Second || got > 5*time.Second {
+			t.Fatalf("Expected jittered delay within 3s-5s, got %v", got)
+		}
+	}
+}
@@ -0,0 +1,882 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mop/provider"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the application configuration, loaded from a config file or environment variables.
+type Config struct {
+	Machines        []MachineConfig
+	Routes          []RouteConfig
+	Backoff         BackoffConfig
+	WakeTimeout     time.Duration // overall deadline for a wake and readiness wait
+	DialTimeout     time.Duration // timeout of a single readiness probe or dial
+	FastTimeout     time.Duration // timeout of the readiness probe tried before waking, 0 disables it
+	KnownUpTTL      time.Duration // how long a successful connection marks a target as up, 0 disables it
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // how long active sessions may drain after SIGTERM/SIGINT
+}
+
+// MachineConfig describes a physical machine or guest and how to wake it.
+// Every route pointing at the same machine shares its wakeup provider.
+type MachineConfig struct {
+	Name                string
+	TargetHost          string
+	TargetMAC           string
+	TargetBroadcastIP   string
+	TargetSecureOn      string
+	WOLInterface        string
+	WOLPort             int
+	WOLTransport        string
+	WOLBurstCount       int
+	WOLBurstInterval    time.Duration
+	WOLResendInterval   time.Duration
+	WOLRelay            string
+	WOLRelayProtocol    string
+	WOLRelaySecret      string
+	ProxmoxAPIURL       string
+	ProxmoxNode         string
+	ProxmoxVMID         string
+	ProxmoxName         string
+	ProxmoxTag          string
+	ProxmoxToken        string
+	ProxmoxUsername     string
+	ProxmoxPassword     string
+	ProxmoxTOTPSecret   string
+	ProxmoxType         string
+	ProxmoxInsecure     bool
+	ProxmoxCAFile       string
+	ProxmoxFingerprints []string
+	ProxmoxTimeout      time.Duration
+	ProxmoxTaskTimeout  time.Duration
+	ProxmoxSleepAction  string
+	ProxmoxAgentTimeout time.Duration
+	WakeupMethod        string
+	SleepCommand        string
+
+	// Discover the host from the guest agent or container interfaces instead of TargetHost.
+	ProxmoxDiscoverHost      bool
+	ProxmoxAddressCIDRs      []string
+	ProxmoxAddressInterfaces []string
+
+	// Guests woken, in order, before this one.
+	ProxmoxDependsOn []provider.ProxmoxDependency
+}
+
+// RouteConfig maps a local listening address to a port on a machine.
+type RouteConfig struct {
+	ProxyHost  string
+	ProxyPort  int
+	Machine    string
+	TargetPort int
+	Probe      ProbeConfig
+}
+
+// ProbeConfig describes how to decide that a route's target is ready for clients.
+type ProbeConfig struct {
+	Type       string // tcp, ssh, http, tls or expect
+	Path       string // http: request path
+	Status     int    // http: expected status code
+	TLS        bool   // http: use https
+	ServerName string // tls: server name to verify, defaults to the target host
+	Insecure   bool   // http, tls: skip certificate verification
+	Send       string // expect: bytes to send, with Go escapes such as \r\n
+	Expect     string // expect: regular expression the response must match
+}
+
+// RelayConfig configures "mop relay", an agent that sends magic packets on its
+// own network on behalf of other mop instances.
+type RelayConfig struct {
+	Listen      string // host:port, for both TCP and UDP
+	Secret      string
+	AllowedMACs []string
+
+	// How the agent sends its packets, like the machine settings WOL_*
+	BroadcastIP string
+	Interface   string
+	Port        int
+	Transport   string
+}
+
+// minRelaySecretLength is the shortest secret mop and a relay agent may share.
+const minRelaySecretLength = 16
+
+// defaultMachineName is the name of the machine configured by the unprefixed
+// TARGET_* and PROXMOX_* variables when ROUTES is not set.
+const defaultMachineName = "default"
+
+// getEnv gets an environment variable or returns a default value.
+func getEnv(key, fallback string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return fallback
+}
+
+// getEnvAsInt gets an integer environment variable.
+func getEnvAsInt(key string, fallback int) (int, error) {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback, nil
+	}
+	val, err := strconv.Atoi(strValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return val, nil
+}
+
+// getEnvAsFloat gets a floating point environment variable.
+func getEnvAsFloat(key string, fallback float64) (float64, error) {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback, nil
+	}
+	val, err := strconv.ParseFloat(strValue, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return val, nil
+}
+
+// getEnvAsDuration gets a duration environment variable such as "1m30s".
+func getEnvAsDuration(key string, fallback time.Duration) (time.Duration, error) {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback, nil
+	}
+	val, err := time.ParseDuration(strValue)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return val, nil
+}
+
+// getEnvAsBool gets a boolean environment variable, falling back on invalid values.
+func getEnvAsBool(key string, fallback bool) bool {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	val, err := strconv.ParseBool(strValue)
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+// getEnvAsList gets a comma-separated list environment variable such as "eth0,ens18".
+func getEnvAsList(key string, fallback []string) []string {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(strValue, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envPrefix returns the environment variable prefix for a machine name,
+// e.g. "gpu-box" becomes "GPU_BOX_".
+func envPrefix(machine string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, strings.ToUpper(machine)) + "_"
+}
+
+// defaultConfig returns a Config with the default global settings and no routes.
+func defaultConfig() *Config {
+	return &Config{
+		Backoff: BackoffConfig{
+			InitialDelay: time.Second,
+			MaxDelay:     15 * time.Second,
+			Multiplier:   2,
+			Jitter:       0.2,
+		},
+		WakeTimeout:     3 * time.Minute,
+		DialTimeout:     3 * time.Second,
+		FastTimeout:     500 * time.Millisecond,
+		KnownUpTTL:      time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// applyGlobalEnv overrides the global settings with any environment variables that are set.
+func applyGlobalEnv(cfg *Config) error {
+	var err error
+	if cfg.Backoff.InitialDelay, err = getEnvAsDuration("RETRY_INITIAL_DELAY", cfg.Backoff.InitialDelay); err != nil {
+		return err
+	}
+	if cfg.Backoff.MaxDelay, err = getEnvAsDuration("RETRY_MAX_DELAY", cfg.Backoff.MaxDelay); err != nil {
+		return err
+	}
+	if cfg.Backoff.Multiplier, err = getEnvAsFloat("RETRY_MULTIPLIER", cfg.Backoff.Multiplier); err != nil {
+		return err
+	}
+	if cfg.Backoff.Jitter, err = getEnvAsFloat("RETRY_JITTER", cfg.Backoff.Jitter); err != nil {
+		return err
+	}
+	if err := applyLegacyRetries(cfg); err != nil {
+		return err
+	}
+	if cfg.WakeTimeout, err = getEnvAsDuration("WAKE_TIMEOUT", cfg.WakeTimeout); err != nil {
+		return err
+	}
+	if cfg.DialTimeout, err = getEnvAsDuration("DIAL_TIMEOUT", cfg.DialTimeout); err != nil {
+		return err
+	}
+	if cfg.FastTimeout, err = getEnvAsDuration("FAST_PATH_TIMEOUT", cfg.FastTimeout); err != nil {
+		return err
+	}
+	if cfg.KnownUpTTL, err = getEnvAsDuration("KNOWN_UP_TTL", cfg.KnownUpTTL); err != nil {
+		return err
+	}
+	if cfg.ShutdownTimeout, err = getEnvAsDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout); err != nil {
+		return err
+	}
+
+	idleMinutes, err := getEnvAsInt("IDLE_SHUTDOWN_MINUTES", int(cfg.IdleTimeout/time.Minute))
+	if err != nil {
+		return err
+	}
+	cfg.IdleTimeout = time.Duration(idleMinutes) * time.Minute
+	return nil
+}
+
+// applyLegacyRetries maps CONNECTION_RETRIES and RETRY_DELAY_SECONDS, which
+// WAKE_TIMEOUT and DIAL_TIMEOUT replaced, to the wait they used to allow for.
+// RETRY_DELAY_SECONDS was both the dial timeout and the pause between attempts,
+// and a machine that is still off doesn't refuse the dial, so every attempt
+// took twice the delay. Either one defaults to its old value, 15 retries and
+// 5 seconds.
+func applyLegacyRetries(cfg *Config) error {
+	if getEnv("CONNECTION_RETRIES", "") == "" && getEnv("RETRY_DELAY_SECONDS", "") == "" {
+		return nil
+	}
+	retries, err := getEnvAsInt("CONNECTION_RETRIES", 15)
+	if err != nil {
+		return err
+	}
+	retryDelay, err := getEnvAsInt("RETRY_DELAY_SECONDS", 5)
+	if err != nil {
+		return err
+	}
+
+	cfg.WakeTimeout = time.Duration(retries*2*retryDelay) * time.Second
+	cfg.DialTimeout = time.Duration(retryDelay) * time.Second
+	log.Printf("CONNECTION_RETRIES and RETRY_DELAY_SECONDS are deprecated, use WAKE_TIMEOUT, DIAL_TIMEOUT and RETRY_* instead. Using a wake timeout of %v and a dial timeout of %v.", cfg.WakeTimeout, cfg.DialTimeout)
+	return nil
+}
+
+// validateGlobal checks the global settings. key maps an environment variable
+// name such as "WAKE_TIMEOUT" to the name the setting was configured under.
+func validateGlobal(cfg *Config, key func(string) string) error {
+	b := cfg.Backoff
+	switch {
+	case b.InitialDelay <= 0:
+		return fmt.Errorf("%s must be positive", key("RETRY_INITIAL_DELAY"))
+	case b.MaxDelay < b.InitialDelay:
+		return fmt.Errorf("%s must not be less than %s", key("RETRY_MAX_DELAY"), key("RETRY_INITIAL_DELAY"))
+	case b.Multiplier < 1:
+		return fmt.Errorf("%s must be at least 1", key("RETRY_MULTIPLIER"))
+	case b.Jitter < 0 || b.Jitter > 1:
+		return fmt.Errorf("%s must be between 0 and 1", key("RETRY_JITTER"))
+	case cfg.WakeTimeout <= 0:
+		return fmt.Errorf("%s must be positive", key("WAKE_TIMEOUT"))
+	case cfg.DialTimeout <= 0:
+		return fmt.Errorf("%s must be positive", key("DIAL_TIMEOUT"))
+	case cfg.FastTimeout < 0:
+		return fmt.Errorf("%s must not be negative", key("FAST_PATH_TIMEOUT"))
+	case cfg.KnownUpTTL < 0:
+		return fmt.Errorf("%s must not be negative", key("KNOWN_UP_TTL"))
+	case cfg.ShutdownTimeout < 0:
+		return fmt.Errorf("%s must not be negative", key("SHUTDOWN_TIMEOUT"))
+	case cfg.IdleTimeout < 0:
+		return fmt.Errorf("%s must not be negative", key("IDLE_SHUTDOWN_MINUTES"))
+	}
+	return nil
+}
+
+// loadConfig loads configuration from the config file at configPath, or from
+// environment variables with defaults when configPath is empty.
+//
+// Without ROUTES, a single route is built from PROXY_PORT, TARGET_HOST and TARGET_PORT.
+// With ROUTES, e.g. "2222:nas:22,8443:nas:443,2223:gpu-box:22", each entry maps a
+// local port to a port on a named machine, and each machine is configured by the
+// usual variables prefixed with its name, e.g. NAS_TARGET_MAC or GPU_BOX_WAKEUP_METHOD.
+// Readiness probe variables are prefixed with the route's proxy port, e.g. ROUTE_2222_READINESS_PROBE.
+func loadConfig(configPath string) (*Config, error) {
+	if configPath != "" {
+		return loadConfigFile(configPath)
+	}
+
+	cfg := defaultConfig()
+	if err := applyGlobalEnv(cfg); err != nil {
+		return nil, err
+	}
+	err := validateGlobal(cfg, func(key string) string {
+		return key
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	proxyHost := getEnv("PROXY_HOST", "0.0.0.0")
+
+	routes := getEnv("ROUTES", "")
+	if routes == "" {
+		machine, err := loadMachineConfig(defaultMachineName, "", cfg.IdleTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		proxyPort, err := getEnvAsInt("PROXY_PORT", 2222)
+		if err != nil {
+			return nil, err
+		}
+
+		targetPort, err := getEnvAsInt("TARGET_PORT", 22)
+		if err != nil {
+			return nil, err
+		}
+		if proxyPort < 1 || proxyPort > 65535 {
+			return nil, fmt.Errorf("PROXY_PORT must be between 1 and 65535, got %d", proxyPort)
+		}
+		if targetPort < 1 || targetPort > 65535 {
+			return nil, fmt.Errorf("TARGET_PORT must be between 1 and 65535, got %d", targetPort)
+		}
+
+		probe, err := loadProbeConfig("")
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.Machines = []MachineConfig{machine}
+		cfg.Routes = []RouteConfig{{
+			ProxyHost:  proxyHost,
+			ProxyPort:  proxyPort,
+			Machine:    machine.Name,
+			TargetPort: targetPort,
+			Probe:      probe,
+		}}
+		return cfg, nil
+	}
+
+	cfg.Routes, err = parseRoutes(routes, proxyHost)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cfg.Routes {
+		cfg.Routes[i].Probe, err = loadProbeConfig(fmt.Sprintf("ROUTE_%d_", cfg.Routes[i].ProxyPort))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range cfg.Routes {
+		if seen[route.Machine] {
+			continue
+		}
+		seen[route.Machine] = true
+
+		machine, err := loadMachineConfig(route.Machine, envPrefix(route.Machine), cfg.IdleTimeout)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Machines = append(cfg.Machines, machine)
+	}
+
+	return cfg, nil
+}
+
+// parseRoutes parses a comma separated list of "proxyPort:machine:targetPort" entries.
+func parseRoutes(value, proxyHost string) ([]RouteConfig, error) {
+	var routes []RouteConfig
+	ports := make(map[int]bool)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid route %q in ROUTES: expected proxyPort:machine:targetPort", entry)
+		}
+
+		proxyPort, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy port in route %q: %v", entry, err)
+		}
+		targetPort, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid target port in route %q: %v", entry, err)
+		}
+
+		if proxyPort < 1 || proxyPort > 65535 {
+			return nil, fmt.Errorf("proxy port in route %q must be between 1 and 65535, got %d", entry, proxyPort)
+		}
+		if targetPort < 1 || targetPort > 65535 {
+			return nil, fmt.Errorf("target port in route %q must be between 1 and 65535, got %d", entry, targetPort)
+		}
+
+		if ports[proxyPort] {
+			return nil, fmt.Errorf("proxy port %d is used by more than one route in ROUTES", proxyPort)
+		}
+		ports[proxyPort] = true
+
+		routes = append(routes, RouteConfig{
+			ProxyHost:  proxyHost,
+			ProxyPort:  proxyPort,
+			Machine:    parts[1],
+			TargetPort: targetPort,
+		})
+	}
+
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("ROUTES does not contain any routes")
+	}
+	return routes, nil
+}
+
+// loadMachineConfig loads the settings of a single machine from environment
+// variables prefixed with prefix. A named machine's host defaults to its name.
+// idleTimeout is the global idle shutdown, which the machine must support.
+func loadMachineConfig(name, prefix string, idleTimeout time.Duration) (MachineConfig, error) {
+	defaultHost := ""
+	if prefix != "" {
+		defaultHost = name
+	}
+
+	mc := MachineConfig{
+		Name:              name,
+		WakeupMethod:      "wol",
+		TargetBroadcastIP: "255.255.255.255",
+		WOLPort:           9,
+		WOLTransport:      provider.WOLTransportUDP,
+		WOLBurstCount:     1,
+		WOLRelayProtocol:  provider.RelayProtocolTCP,
+		ProxmoxType:       "qemu", // default to qemu (VM), can be lxc
+	}
+	if err := applyMachineEnv(&mc, prefix); err != nil {
+		return MachineConfig{}, err
+	}
+	if mc.TargetHost == "" && !mc.discoversHost() {
+		mc.TargetHost = defaultHost
+	}
+
+	err := validateMachine(mc, idleTimeout, func(key string) string {
+		return prefix + key
+	})
+	return mc, err
+}
+
+// applyMachineEnv overrides machine settings with any environment variables
+// that are set, using the same prefix as loadMachineConfig.
+func applyMachineEnv(mc *MachineConfig, prefix string) error {
+	env := func(key, fallback string) string {
+		return getEnv(prefix+key, fallback)
+	}
+
+	mc.TargetHost = env("TARGET_HOST", mc.TargetHost)
+	mc.TargetMAC = env("TARGET_MAC", mc.TargetMAC)
+	mc.TargetBroadcastIP = env("TARGET_BROADCAST_IP", mc.TargetBroadcastIP)
+	mc.TargetSecureOn = env("TARGET_SECUREON", mc.TargetSecureOn)
+	mc.WOLInterface = env("WOL_INTERFACE", mc.WOLInterface)
+	mc.WOLTransport = strings.ToLower(env("WOL_TRANSPORT", mc.WOLTransport))
+	mc.WOLRelay = env("WOL_RELAY", mc.WOLRelay)
+	mc.WOLRelayProtocol = strings.ToLower(env("WOL_RELAY_PROTOCOL", mc.WOLRelayProtocol))
+	mc.WOLRelaySecret = env("WOL_RELAY_SECRET", mc.WOLRelaySecret)
+	mc.ProxmoxAPIURL = env("PROXMOX_API_URL", mc.ProxmoxAPIURL)
+	mc.ProxmoxNode = env("PROXMOX_NODE", mc.ProxmoxNode)
+	mc.ProxmoxVMID = env("PROXMOX_VMID", mc.ProxmoxVMID)
+	mc.ProxmoxName = env("PROXMOX_NAME", mc.ProxmoxName)
+	mc.ProxmoxTag = env("PROXMOX_TAG", mc.ProxmoxTag)
+	mc.ProxmoxToken = env("PROXMOX_TOKEN", mc.ProxmoxToken)
+	mc.ProxmoxUsername = env("PROXMOX_USERNAME", mc.ProxmoxUsername)
+	mc.ProxmoxPassword = env("PROXMOX_PASSWORD", mc.ProxmoxPassword)
+	mc.ProxmoxTOTPSecret = env("PROXMOX_TOTP_SECRET", mc.ProxmoxTOTPSecret)
+	mc.ProxmoxType = env("PROXMOX_TYPE", mc.ProxmoxType)
+	mc.ProxmoxInsecure = getEnvAsBool(prefix+"PROXMOX_INSECURE", mc.ProxmoxInsecure)
+	mc.ProxmoxCAFile = env("PROXMOX_CA_FILE", mc.ProxmoxCAFile)
+	mc.ProxmoxFingerprints = getEnvAsList(prefix+"PROXMOX_FINGERPRINTS", mc.ProxmoxFingerprints)
+	mc.ProxmoxSleepAction = strings.ToLower(env("PROXMOX_SLEEP_ACTION", mc.ProxmoxSleepAction))
+	mc.ProxmoxDiscoverHost = getEnvAsBool(prefix+"PROXMOX_DISCOVER_HOST", mc.ProxmoxDiscoverHost)
+	mc.ProxmoxAddressCIDRs = getEnvAsList(prefix+"PROXMOX_ADDRESS_CIDRS", mc.ProxmoxAddressCIDRs)
+	mc.ProxmoxAddressInterfaces = getEnvAsList(prefix+"PROXMOX_ADDRESS_INTERFACES", mc.ProxmoxAddressInterfaces)
+	mc.WakeupMethod = strings.ToLower(env("WAKEUP_METHOD", mc.WakeupMethod))
+	mc.SleepCommand = env("SLEEP_COMMAND", mc.SleepCommand)
+
+	var err error
+	if mc.WOLPort, err = getEnvAsInt(prefix+"WOL_PORT", mc.WOLPort); err != nil {
+		return err
+	}
+	if mc.WOLBurstCount, err = getEnvAsInt(prefix+"WOL_BURST_COUNT", mc.WOLBurstCount); err != nil {
+		return err
+	}
+	if mc.WOLBurstInterval, err = getEnvAsDuration(prefix+"WOL_BURST_INTERVAL", mc.WOLBurstInterval); err != nil {
+		return err
+	}
+	if mc.WOLResendInterval, err = getEnvAsDuration(prefix+"WOL_RESEND_INTERVAL", mc.WOLResendInterval); err != nil {
+		return err
+	}
+	if mc.ProxmoxTimeout, err = getEnvAsDuration(prefix+"PROXMOX_TIMEOUT", mc.ProxmoxTimeout); err != nil {
+		return err
+	}
+	if mc.ProxmoxTaskTimeout, err = getEnvAsDuration(prefix+"PROXMOX_TASK_TIMEOUT", mc.ProxmoxTaskTimeout); err != nil {
+		return err
+	}
+	if mc.ProxmoxAgentTimeout, err = getEnvAsDuration(prefix+"PROXMOX_AGENT_TIMEOUT", mc.ProxmoxAgentTimeout); err != nil {
+		return err
+	}
+	if value := getEnv(prefix+"PROXMOX_DEPENDS_ON", ""); value != "" {
+		if mc.ProxmoxDependsOn, err = parseDependsOn(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %v", prefix+"PROXMOX_DEPENDS_ON", err)
+		}
+	}
+	return nil
+}
+
+// parseDependsOn parses a comma separated list of "guest[/ready]" entries. A
+// numeric guest is a VMID, anything else a guest name. ready is "running",
+// "agent" or "tcp=host:port".
+func parseDependsOn(value string) ([]provider.ProxmoxDependency, error) {
+	var deps []provider.ProxmoxDependency
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		guest, ready, _ := strings.Cut(entry, "/")
+		if guest == "" {
+			return nil, fmt.Errorf("invalid dependency %q: expected guest[/ready]", entry)
+		}
+
+		var dep provider.ProxmoxDependency
+		if _, err := strconv.Atoi(guest); err == nil {
+			dep.VMID = guest
+		} else {
+			dep.Name = guest
+		}
+		ready, dep.Address, _ = strings.Cut(ready, "=")
+		dep.Ready = strings.ToLower(ready)
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// validateMachine checks that a machine has the settings its wakeup method needs.
+// key maps an environment variable name such as "TARGET_MAC" to the name the
+// setting was configured under, so errors point at the right variable or file key.
+func validateMachine(mc MachineConfig, idleTimeout time.Duration, key func(string) string) error {
+	if mc.TargetHost == "" && !mc.discoversHost() {
+		return fmt.Errorf("%s is required", key("TARGET_HOST"))
+	}
+
+	// Validation depends on wakeup method
+	switch mc.WakeupMethod {
+	case "wol":
+		if mc.TargetMAC == "" {
+			return fmt.Errorf("%s is required when %s is 'wol'", key("TARGET_MAC"), key("WAKEUP_METHOD"))
+		}
+		// Wake-on-LAN can't power a machine down on its own
+		if idleTimeout > 0 && mc.SleepCommand == "" {
+			return fmt.Errorf("%s is required when idle shutdown is enabled", key("SLEEP_COMMAND"))
+		}
+		if mc.TargetSecureOn != "" {
+			if _, err := provider.ParseSecureOn(mc.TargetSecureOn); err != nil {
+				return fmt.Errorf("%s: %v", key("TARGET_SECUREON"), err)
+			}
+		}
+		err := validateWOLSender(mc.WOLInterface, mc.WOLPort, mc.WOLTransport, key("WOL_INTERFACE"), key("WOL_PORT"), key("WOL_TRANSPORT"))
+		if err != nil {
+			return err
+		}
+		if mc.WOLBurstCount < 1 {
+			return fmt.Errorf("%s must be at least 1, got %d", key("WOL_BURST_COUNT"), mc.WOLBurstCount)
+		}
+		if mc.WOLBurstInterval < 0 || mc.WOLResendInterval < 0 {
+			return fmt.Errorf("%s and %s must not be negative", key("WOL_BURST_INTERVAL"), key("WOL_RESEND_INTERVAL"))
+		}
+		if mc.WOLRelay != "" {
+			if _, _, err := net.SplitHostPort(mc.WOLRelay); err != nil {
+				return fmt.Errorf("%s must be host:port of a relay agent: %v", key("WOL_RELAY"), err)
+			}
+			if mc.WOLRelayProtocol != provider.RelayProtocolTCP && mc.WOLRelayProtocol != provider.RelayProtocolUDP {
+				return fmt.Errorf("%s has unknown protocol %q, expected tcp or udp", key("WOL_RELAY_PROTOCOL"), mc.WOLRelayProtocol)
+			}
+			if err := validateRelaySecret(mc.WOLRelaySecret, key("WOL_RELAY_SECRET")); err != nil {
+				return err
+			}
+			// The relay agent decides how its packets are sent
+			if mc.WOLInterface != "" || mc.WOLTransport != provider.WOLTransportUDP {
+				return fmt.Errorf("%s cannot be combined with %s or %s, set them on the relay agent", key("WOL_RELAY"), key("WOL_INTERFACE"), key("WOL_TRANSPORT"))
+			}
+		}
+	case "proxmox":
+		if mc.ProxmoxAPIURL == "" {
+			return fmt.Errorf("%s is required when %s is 'proxmox'", key("PROXMOX_API_URL"), key("WAKEUP_METHOD"))
+		}
+		// An API token is the default; a username and password are used without one.
+		if mc.ProxmoxToken == "" {
+			if mc.ProxmoxUsername == "" && mc.ProxmoxPassword == "" {
+				return fmt.Errorf("%s, or %s and %s, is required when %s is 'proxmox'",
+					key("PROXMOX_TOKEN"), key("PROXMOX_USERNAME"), key("PROXMOX_PASSWORD"), key("WAKEUP_METHOD"))
+			}
+			if mc.ProxmoxUsername == "" {
+				return fmt.Errorf("%s is required when %s is set", key("PROXMOX_USERNAME"), key("PROXMOX_PASSWORD"))
+			}
+			if mc.ProxmoxPassword == "" {
+				return fmt.Errorf("%s is required when %s is set", key("PROXMOX_PASSWORD"), key("PROXMOX_USERNAME"))
+			}
+		}
+		if mc.ProxmoxTOTPSecret != "" {
+			if _, err := provider.ParseTOTPSecret(mc.ProxmoxTOTPSecret); err != nil {
+				return fmt.Errorf("%s: %v", key("PROXMOX_TOTP_SECRET"), err)
+			}
+		}
+		if mc.ProxmoxVMID == "" && mc.ProxmoxName == "" && mc.ProxmoxTag == "" {
+			return fmt.Errorf("%s, %s or %s is required when %s is 'proxmox'",
+				key("PROXMOX_VMID"), key("PROXMOX_NAME"), key("PROXMOX_TAG"), key("WAKEUP_METHOD"))
+		}
+		if _, err := strconv.Atoi(mc.ProxmoxVMID); mc.ProxmoxVMID != "" && err != nil {
+			return fmt.Errorf("%s must be a number, got %q", key("PROXMOX_VMID"), mc.ProxmoxVMID)
+		}
+		if mc.ProxmoxInsecure && (mc.ProxmoxCAFile != "" || len(mc.ProxmoxFingerprints) > 0) {
+			return fmt.Errorf("%s cannot be combined with %s or %s",
+				key("PROXMOX_INSECURE"), key("PROXMOX_CA_FILE"), key("PROXMOX_FINGERPRINTS"))
+		}
+		if mc.ProxmoxCAFile != "" {
+			if _, err := provider.LoadCAFile(mc.ProxmoxCAFile); err != nil {
+				return fmt.Errorf("%s: %v", key("PROXMOX_CA_FILE"), err)
+			}
+		}
+		for _, fingerprint := range mc.ProxmoxFingerprints {
+			if _, err := provider.ParseFingerprint(fingerprint); err != nil {
+				return fmt.Errorf("%s: %v", key("PROXMOX_FINGERPRINTS"), err)
+			}
+		}
+		if mc.ProxmoxTimeout < 0 {
+			return fmt.Errorf("%s must not be negative", key("PROXMOX_TIMEOUT"))
+		}
+		if mc.ProxmoxTaskTimeout < 0 {
+			return fmt.Errorf("%s must not be negative", key("PROXMOX_TASK_TIMEOUT"))
+		}
+		if mc.ProxmoxAgentTimeout < 0 {
+			return fmt.Errorf("%s must not be negative", key("PROXMOX_AGENT_TIMEOUT"))
+		}
+		if mc.ProxmoxAgentTimeout > 0 && mc.ProxmoxType == "lxc" {
+			return fmt.Errorf("%s is only supported for qemu VMs", key("PROXMOX_AGENT_TIMEOUT"))
+		}
+		switch mc.ProxmoxSleepAction {
+		case "", provider.ProxmoxSleepShutdown:
+		case provider.ProxmoxSleepSuspend, provider.ProxmoxSleepHibernate:
+			if mc.ProxmoxType == "lxc" {
+				return fmt.Errorf("%s %q is only supported for qemu VMs", key("PROXMOX_SLEEP_ACTION"), mc.ProxmoxSleepAction)
+			}
+		default:
+			return fmt.Errorf("%s has unknown sleep action %q, expected shutdown, suspend or hibernate", key("PROXMOX_SLEEP_ACTION"), mc.ProxmoxSleepAction)
+		}
+		for i, dep := range mc.ProxmoxDependsOn {
+			if err := validateDependency(dep); err != nil {
+				return fmt.Errorf("%s[%d]: %v", key("PROXMOX_DEPENDS_ON"), i, err)
+			}
+		}
+		for _, cidr := range mc.ProxmoxAddressCIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				return fmt.Errorf("%s contains an invalid CIDR: %v", key("PROXMOX_ADDRESS_CIDRS"), err)
+			}
+		}
+	case "noop":
+	default:
+		return fmt.Errorf("%s has unknown wakeup method %q", key("WAKEUP_METHOD"), mc.WakeupMethod)
+	}
+
+	return nil
+}
+
+// loadRelayConfig loads the relay agent's configuration from the RELAY_*
+// environment variables.
+func loadRelayConfig() (*RelayConfig, error) {
+	rc := &RelayConfig{
+		Listen:      getEnv("RELAY_LISTEN", "0.0.0.0:9009"),
+		Secret:      getEnv("RELAY_SECRET", ""),
+		AllowedMACs: getEnvAsList("RELAY_ALLOWED_MACS", nil),
+		BroadcastIP: getEnv("RELAY_BROADCAST_IP", "255.255.255.255"),
+		Interface:   getEnv("RELAY_INTERFACE", ""),
+		Transport:   strings.ToLower(getEnv("RELAY_TRANSPORT", provider.WOLTransportUDP)),
+	}
+	var err error
+	if rc.Port, err = getEnvAsInt("RELAY_PORT", 9); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := net.SplitHostPort(rc.Listen); err != nil {
+		return nil, fmt.Errorf("invalid value for RELAY_LISTEN: %v", err)
+	}
+	if err := validateRelaySecret(rc.Secret, "RELAY_SECRET"); err != nil {
+		return nil, err
+	}
+	for _, mac := range rc.AllowedMACs {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return nil, fmt.Errorf("RELAY_ALLOWED_MACS: %v", err)
+		}
+	}
+	if err := validateWOLSender(rc.Interface, rc.Port, rc.Transport, "RELAY_INTERFACE", "RELAY_PORT", "RELAY_TRANSPORT"); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// validateWOLSender checks how magic packets are sent, by a machine's
+// WOLProvider or a relay agent. The keys name the settings in errors.
+func validateWOLSender(iface string, port int, transport string, ifaceKey, portKey, transportKey string) error {
+	if addr, err := netip.ParseAddr(iface); err == nil && !addr.Is4() {
+		return fmt.Errorf("%s must be an interface name, an IPv4 address or %q, got %s", ifaceKey, provider.WOLAllInterfaces, addr)
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s must be between 1 and 65535, got %d", portKey, port)
+	}
+	switch transport {
+	case provider.WOLTransportUDP:
+	case provider.WOLTransportEthernet:
+		if iface == "" {
+			return fmt.Errorf("%s is required when %s is %q", ifaceKey, transportKey, transport)
+		}
+	default:
+		return fmt.Errorf("%s has unknown transport %q, expected udp or ethernet", transportKey, transport)
+	}
+	return nil
+}
+
+// validateRelaySecret checks the secret shared by mop and its relay agent.
+func validateRelaySecret(secret, key string) error {
+	if len(secret) < minRelaySecretLength {
+		return fmt.Errorf("%s must be at least %d characters long", key, minRelaySecretLength)
+	}
+	return nil
+}
+
+// validateDependency checks a guest a Proxmox machine depends on.
+func validateDependency(dep provider.ProxmoxDependency) error {
+	if dep.VMID == "" && dep.Name == "" && dep.Tag == "" {
+		return fmt.Errorf("vmid, name or tag is required")
+	}
+	if _, err := strconv.Atoi(dep.VMID); dep.VMID != "" && err != nil {
+		return fmt.Errorf("vmid must be a number, got %q", dep.VMID)
+	}
+	if dep.Type != "" && dep.Type != "qemu" && dep.Type != "lxc" {
+		return fmt.Errorf("unknown type %q, expected qemu or lxc", dep.Type)
+	}
+	if dep.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+
+	switch dep.Ready {
+	case "", provider.ProxmoxReadyRunning:
+	case provider.ProxmoxReadyAgent:
+		if dep.Type == "lxc" {
+			return fmt.Errorf("ready %q is only supported for qemu VMs", dep.Ready)
+		}
+	case provider.ProxmoxReadyTCP:
+		if _, _, err := net.SplitHostPort(dep.Address); err != nil {
+			return fmt.Errorf("ready %q needs an address such as 192.168.1.20:2049: %v", dep.Ready, err)
+		}
+	default:
+		return fmt.Errorf("unknown ready check %q, expected running, agent or tcp", dep.Ready)
+	}
+	return nil
+}
+
+// discoversHost reports whether the machine's host is discovered by its wakeup
+// provider rather than configured.
+func (mc MachineConfig) discoversHost() bool {
+	return mc.WakeupMethod == "proxmox" && mc.ProxmoxDiscoverHost
+}
+
+// loadProbeConfig loads a route's readiness probe from environment variables prefixed with prefix.
+func loadProbeConfig(prefix string) (ProbeConfig, error) {
+	status, err := getEnvAsInt(prefix+"PROBE_HTTP_STATUS", 200)
+	if err != nil {
+		return ProbeConfig{}, err
+	}
+
+	pc := ProbeConfig{
+		Type:       strings.ToLower(getEnv(prefix+"READINESS_PROBE", "tcp")),
+		Path:       getEnv(prefix+"PROBE_HTTP_PATH", "/"),
+		Status:     status,
+		TLS:        getEnvAsBool(prefix+"PROBE_TLS", false),
+		ServerName: getEnv(prefix+"PROBE_SERVER_NAME", ""),
+		Insecure:   getEnvAsBool(prefix+"PROBE_INSECURE", false),
+		Send:       getEnv(prefix+"PROBE_SEND", ""),
+		Expect:     getEnv(prefix+"PROBE_EXPECT", ""),
+	}
+
+	err = validateProbe(pc, func(key string) string {
+		return prefix + key
+	})
+	return pc, err
+}
+
+// validateProbe checks a readiness probe's settings. key maps an environment
+// variable name such as "PROBE_EXPECT" to the name the setting was configured under.
+func validateProbe(pc ProbeConfig, key func(string) string) error {
+	switch pc.Type {
+	case "tcp", "ssh", "tls":
+	case "http":
+		if pc.Status < 100 || pc.Status > 599 {
+			return fmt.Errorf("%s must be a valid HTTP status code, got %d", key("PROBE_HTTP_STATUS"), pc.Status)
+		}
+	case "expect":
+		if pc.Expect == "" {
+			return fmt.Errorf("%s is required when %s is 'expect'", key("PROBE_EXPECT"), key("READINESS_PROBE"))
+		}
+		if _, err := regexp.Compile(pc.Expect); err != nil {
+			return fmt.Errorf("%s is not a valid regular expression: %v", key("PROBE_EXPECT"), err)
+		}
+		if _, err := unescapeProbeSend(pc.Send); err != nil {
+			return fmt.Errorf("%s contains an invalid escape sequence: %v", key("PROBE_SEND"), err)
+		}
+	default:
+		return fmt.Errorf("%s has unknown readiness probe %q", key("READINESS_PROBE"), pc.Type)
+	}
+	return nil
+}
+
+// unescapeProbeSend interprets Go escape sequences such as \r\n in an expect probe's payload.
+func unescapeProbeSend(send string) (string, error) {
+	var b strings.Builder
+	for len(send) > 0 {
+		value, multibyte, tail, err := strconv.UnquoteChar(send, 0)
+		if err != nil {
+			return "", err
+		}
+		if multibyte {
+			b.WriteRune(value)
+		} else {
+			b.WriteByte(byte(value))
+		}
+		send = tail
+	}
+	return b.String(), nil
+}
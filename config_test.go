@@ -0,0 +1,578 @@
+package main
+
+import (
+	"mop/provider"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	// Save original env
+	originalEnv := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, e := range originalEnv {
+			pair := splitEnv(e)
+			os.Setenv(pair[0], pair[1])
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expectErr bool
+	}{
+		{
+			name: "Valid WOL Config",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"TARGET_MAC":    "AA:BB:CC:DD:EE:FF",
+				"WAKEUP_METHOD": "wol",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Missing Target Host",
+			env: map[string]string{
+				"TARGET_MAC": "AA:BB:CC:DD:EE:FF",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Missing MAC for WOL",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"WAKEUP_METHOD": "wol",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid Wake Timeout",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"WAKEUP_METHOD": "noop",
+				"WAKE_TIMEOUT":  "0s",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Max Delay Below Initial Delay",
+			env: map[string]string{
+				"TARGET_HOST":         "example.com",
+				"WAKEUP_METHOD":       "noop",
+				"RETRY_INITIAL_DELAY": "10s",
+				"RETRY_MAX_DELAY":     "5s",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL Idle Shutdown Without Sleep Command",
+			env: map[string]string{
+				"TARGET_HOST":           "example.com",
+				"TARGET_MAC":            "AA:BB:CC:DD:EE:FF",
+				"IDLE_SHUTDOWN_MINUTES": "30",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL Idle Shutdown With Sleep Command",
+			env: map[string]string{
+				"TARGET_HOST":           "example.com",
+				"TARGET_MAC":            "AA:BB:CC:DD:EE:FF",
+				"IDLE_SHUTDOWN_MINUTES": "30",
+				"SLEEP_COMMAND":         "ssh example.com systemctl suspend",
+			},
+			expectErr: false,
+		},
+		{
+			name: "WOL SecureOn Password",
+			env: map[string]string{
+				"TARGET_HOST":     "example.com",
+				"TARGET_MAC":      "AA:BB:CC:DD:EE:FF",
+				"TARGET_SECUREON": "01:23:45:67:89:AB",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Invalid WOL SecureOn Password",
+			env: map[string]string{
+				"TARGET_HOST":     "example.com",
+				"TARGET_MAC":      "AA:BB:CC:DD:EE:FF",
+				"TARGET_SECUREON": "01:23:45",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL On All Interfaces",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"TARGET_MAC":    "AA:BB:CC:DD:EE:FF",
+				"WOL_INTERFACE": "all",
+				"WOL_PORT":      "7",
+				"WOL_TRANSPORT": "Ethernet",
+			},
+			expectErr: false,
+		},
+		{
+			name: "WOL Over Ethernet Without Interface",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"TARGET_MAC":    "AA:BB:CC:DD:EE:FF",
+				"WOL_TRANSPORT": "ethernet",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL Bursts And Resends",
+			env: map[string]string{
+				"TARGET_HOST":         "example.com",
+				"TARGET_MAC":          "AA:BB:CC:DD:EE:FF",
+				"WOL_BURST_COUNT":     "5",
+				"WOL_BURST_INTERVAL":  "200ms",
+				"WOL_RESEND_INTERVAL": "10s",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Empty WOL Burst",
+			env: map[string]string{
+				"TARGET_HOST":     "example.com",
+				"TARGET_MAC":      "AA:BB:CC:DD:EE:FF",
+				"WOL_BURST_COUNT": "-1",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL Relay",
+			env: map[string]string{
+				"TARGET_HOST":        "example.com",
+				"TARGET_MAC":         "AA:BB:CC:DD:EE:FF",
+				"WOL_RELAY":          "192.168.1.2:9009",
+				"WOL_RELAY_PROTOCOL": "UDP",
+				"WOL_RELAY_SECRET":   "0123456789abcdef",
+			},
+			expectErr: false,
+		},
+		{
+			name: "WOL Relay With Short Secret",
+			env: map[string]string{
+				"TARGET_HOST":      "example.com",
+				"TARGET_MAC":       "AA:BB:CC:DD:EE:FF",
+				"WOL_RELAY":        "192.168.1.2:9009",
+				"WOL_RELAY_SECRET": "secret",
+			},
+			expectErr: true,
+		},
+		{
+			name: "WOL Relay With Interface",
+			env: map[string]string{
+				"TARGET_HOST":      "example.com",
+				"TARGET_MAC":       "AA:BB:CC:DD:EE:FF",
+				"WOL_RELAY":        "192.168.1.2:9009",
+				"WOL_RELAY_SECRET": "0123456789abcdef",
+				"WOL_INTERFACE":    "eth0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid WOL Port",
+			env: map[string]string{
+				"TARGET_HOST": "example.com",
+				"TARGET_MAC":  "AA:BB:CC:DD:EE:FF",
+				"WOL_PORT":    "70000",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Unknown Proxmox Sleep Action",
+			env: map[string]string{
+				"TARGET_HOST":          "example.com",
+				"WAKEUP_METHOD":        "proxmox",
+				"PROXMOX_API_URL":      "https://pve:8006/api2/json",
+				"PROXMOX_NODE":         "pve1",
+				"PROXMOX_VMID":         "100",
+				"PROXMOX_TOKEN":        "user@pam!token=secret",
+				"PROXMOX_SLEEP_ACTION": "reboot",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Discovered Host (No Target Host)",
+			env: map[string]string{
+				"WAKEUP_METHOD":         "proxmox",
+				"PROXMOX_API_URL":       "https://pve:8006/api2/json",
+				"PROXMOX_VMID":          "100",
+				"PROXMOX_TOKEN":         "user@pam!token=secret",
+				"PROXMOX_DISCOVER_HOST": "true",
+				"PROXMOX_ADDRESS_CIDRS": "192.168.1.0/24, 10.0.0.0/8",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Invalid Address CIDR",
+			env: map[string]string{
+				"WAKEUP_METHOD":         "proxmox",
+				"PROXMOX_API_URL":       "https://pve:8006/api2/json",
+				"PROXMOX_VMID":          "100",
+				"PROXMOX_TOKEN":         "user@pam!token=secret",
+				"PROXMOX_DISCOVER_HOST": "true",
+				"PROXMOX_ADDRESS_CIDRS": "192.168.1.0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Proxmox Password Login",
+			env: map[string]string{
+				"TARGET_HOST":         "example.com",
+				"WAKEUP_METHOD":       "proxmox",
+				"PROXMOX_API_URL":     "https://pve:8006/api2/json",
+				"PROXMOX_VMID":        "100",
+				"PROXMOX_USERNAME":    "mop@pve",
+				"PROXMOX_PASSWORD":    "secret",
+				"PROXMOX_TOTP_SECRET": "GEZDGNBVGY3TQOJQ",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Proxmox Username Without Password",
+			env: map[string]string{
+				"TARGET_HOST":      "example.com",
+				"WAKEUP_METHOD":    "proxmox",
+				"PROXMOX_API_URL":  "https://pve:8006/api2/json",
+				"PROXMOX_VMID":     "100",
+				"PROXMOX_USERNAME": "mop@pve",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid Proxmox TOTP Secret",
+			env: map[string]string{
+				"TARGET_HOST":         "example.com",
+				"WAKEUP_METHOD":       "proxmox",
+				"PROXMOX_API_URL":     "https://pve:8006/api2/json",
+				"PROXMOX_VMID":        "100",
+				"PROXMOX_USERNAME":    "mop@pve",
+				"PROXMOX_PASSWORD":    "secret",
+				"PROXMOX_TOTP_SECRET": "123456",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Proxmox Dependencies",
+			env: map[string]string{
+				"TARGET_HOST":        "example.com",
+				"WAKEUP_METHOD":      "proxmox",
+				"PROXMOX_API_URL":    "https://pve:8006/api2/json",
+				"PROXMOX_VMID":       "110",
+				"PROXMOX_TOKEN":      "user@pam!token=secret",
+				"PROXMOX_DEPENDS_ON": "105/tcp=192.168.1.5:2049, router/agent",
+			},
+			expectErr: false,
+		},
+		{
+			name: "Proxmox Dependency Without TCP Address",
+			env: map[string]string{
+				"TARGET_HOST":        "example.com",
+				"WAKEUP_METHOD":      "proxmox",
+				"PROXMOX_API_URL":    "https://pve:8006/api2/json",
+				"PROXMOX_VMID":       "110",
+				"PROXMOX_TOKEN":      "user@pam!token=secret",
+				"PROXMOX_DEPENDS_ON": "105/tcp",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Valid Noop Config (No MAC)",
+			env: map[string]string{
+				"TARGET_HOST":   "example.com",
+				"WAKEUP_METHOD": "noop",
+			},
+			expectErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			_, err := loadConfig("")
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLegacyRetries(t *testing.T) {
+	originalEnv := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, e := range originalEnv {
+			pair := splitEnv(e)
+			os.Setenv(pair[0], pair[1])
+		}
+	}()
+
+	tests := []struct {
+		name         string
+		env          map[string]string
+		expectedWake time.Duration
+		expectedDial time.Duration
+	}{
+		{name: "Not Set", env: map[string]string{}, expectedWake: 3 * time.Minute, expectedDial: 3 * time.Second},
+		{name: "Both Set", env: map[string]string{"CONNECTION_RETRIES": "10", "RETRY_DELAY_SECONDS": "3"}, expectedWake: time.Minute, expectedDial: 3 * time.Second},
+		{name: "Retries Only", env: map[string]string{"CONNECTION_RETRIES": "4"}, expectedWake: 40 * time.Second, expectedDial: 5 * time.Second},
+		{name: "Delay Only", env: map[string]string{"RETRY_DELAY_SECONDS": "5"}, expectedWake: 150 * time.Second, expectedDial: 5 * time.Second},
+		{name: "New Variables Win", env: map[string]string{"CONNECTION_RETRIES": "10", "WAKE_TIMEOUT": "5m", "DIAL_TIMEOUT": "2s"}, expectedWake: 5 * time.Minute, expectedDial: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			os.Setenv("TARGET_HOST", "example.com")
+			os.Setenv("TARGET_MAC", "AA:BB:CC:DD:EE:FF")
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cfg.WakeTimeout != tt.expectedWake {
+				t.Errorf("Expected wake timeout %v, got %v", tt.expectedWake, cfg.WakeTimeout)
+			}
+			if cfg.DialTimeout != tt.expectedDial {
+				t.Errorf("Expected dial timeout %v, got %v", tt.expectedDial, cfg.DialTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRoutes(t *testing.T) {
+	originalEnv := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, e := range originalEnv {
+			pair := splitEnv(e)
+			os.Setenv(pair[0], pair[1])
+		}
+	}()
+
+	os.Clearenv()
+	os.Setenv("ROUTES", "2222:nas:22, 8443:nas:443,2223:gpu-box:22")
+	os.Setenv("NAS_TARGET_HOST", "192.168.1.10")
+	os.Setenv("NAS_TARGET_MAC", "AA:BB:CC:DD:EE:FF")
+	os.Setenv("GPU_BOX_WAKEUP_METHOD", "noop")
+	os.Setenv("ROUTE_2222_READINESS_PROBE", "ssh")
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(cfg.Routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %d", len(cfg.Routes))
+	}
+	expectedRoute := RouteConfig{ProxyHost: "0.0.0.0", ProxyPort: 8443, Machine: "nas", TargetPort: 443, Probe: ProbeConfig{Type: "tcp", Path: "/", Status: 200}}
+	if cfg.Routes[1] != expectedRoute {
+		t.Errorf("Expected route %+v, got %+v", expectedRoute, cfg.Routes[1])
+	}
+
+	if cfg.Routes[0].Probe.Type != "ssh" {
+		t.Errorf("Expected ssh readiness probe for port 2222, got %q", cfg.Routes[0].Probe.Type)
+	}
+
+	if len(cfg.Machines) != 2 {
+		t.Fatalf("Expected 2 machines shared by the routes, got %d", len(cfg.Machines))
+	}
+	if cfg.Machines[0].Name != "nas" || cfg.Machines[0].TargetHost != "192.168.1.10" || cfg.Machines[0].WakeupMethod != "wol" {
+		t.Errorf("Unexpected nas machine: %+v", cfg.Machines[0])
+	}
+	if cfg.Machines[1].Name != "gpu-box" || cfg.Machines[1].TargetHost != "gpu-box" || cfg.Machines[1].WakeupMethod != "noop" {
+		t.Errorf("Unexpected gpu-box machine: %+v", cfg.Machines[1])
+	}
+}
+
+func TestLoadConfigRoutesErrors(t *testing.T) {
+	originalEnv := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, e := range originalEnv {
+			pair := splitEnv(e)
+			os.Setenv(pair[0], pair[1])
+		}
+	}()
+
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "Malformed Route",
+			env:  map[string]string{"ROUTES": "2222:nas", "NAS_WAKEUP_METHOD": "noop"},
+		},
+		{
+			name: "Invalid Port",
+			env:  map[string]string{"ROUTES": "ssh:nas:22", "NAS_WAKEUP_METHOD": "noop"},
+		},
+		{
+			name: "Proxy Port Out Of Range",
+			env:  map[string]string{"ROUTES": "70000:nas:22", "NAS_WAKEUP_METHOD": "noop"},
+		},
+		{
+			name: "Target Port Out Of Range",
+			env:  map[string]string{"ROUTES": "2222:nas:0", "NAS_WAKEUP_METHOD": "noop"},
+		},
+		{
+			name: "Duplicate Proxy Port",
+			env:  map[string]string{"ROUTES": "2222:nas:22,2222:nas:443", "NAS_WAKEUP_METHOD": "noop"},
+		},
+		{
+			name: "Missing MAC for Prefixed Machine",
+			env:  map[string]string{"ROUTES": "2222:nas:22"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			if _, err := loadConfig(""); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestLoadProbeConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expectErr bool
+	}{
+		{name: "Default TCP", env: map[string]string{}, expectErr: false},
+		{name: "SSH Banner", env: map[string]string{"READINESS_PROBE": "SSH"}, expectErr: false},
+		{name: "HTTP Status", env: map[string]string{"READINESS_PROBE": "http", "PROBE_HTTP_STATUS": "204"}, expectErr: false},
+		{name: "Invalid HTTP Status", env: map[string]string{"READINESS_PROBE": "http", "PROBE_HTTP_STATUS": "42"}, expectErr: true},
+		{name: "Expect", env: map[string]string{"READINESS_PROBE": "expect", "PROBE_SEND": `PING\r\n`, "PROBE_EXPECT": `^\+PONG`}, expectErr: false},
+		{name: "Expect Without Pattern", env: map[string]string{"READINESS_PROBE": "expect"}, expectErr: true},
+		{name: "Expect Invalid Pattern", env: map[string]string{"READINESS_PROBE": "expect", "PROBE_EXPECT": "("}, expectErr: true},
+		{name: "Unknown Probe", env: map[string]string{"READINESS_PROBE": "icmp"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			pc, err := loadProbeConfig("")
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if err == nil {
+				if _, err := newProbe(pc); err != nil {
+					t.Errorf("Valid config failed to build a probe: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnescapeProbeSend(t *testing.T) {
+	got, err := unescapeProbeSend(`GET / HTTP/1.0\r\nUser-Agent: "mop"\r\n\r\n`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := "GET / HTTP/1.0\r\nUser-Agent: \"mop\"\r\n\r\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Payloads already unescaped by YAML pass through unchanged.
+	got, err = unescapeProbeSend("PING\r\n")
+	if err != nil || got != "PING\r\n" {
+		t.Errorf("Expected literal CRLF to be kept, got %q, %v", got, err)
+	}
+}
+
+func TestLoadRelayConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expectErr bool
+	}{
+		{name: "Defaults", env: map[string]string{"RELAY_SECRET": "0123456789abcdef"}, expectErr: false},
+		{name: "Raw Ethernet", env: map[string]string{"RELAY_SECRET": "0123456789abcdef", "RELAY_INTERFACE": "eth0", "RELAY_TRANSPORT": "ethernet"}, expectErr: false},
+		{name: "Missing Secret", env: map[string]string{}, expectErr: true},
+		{name: "Invalid Listen Address", env: map[string]string{"RELAY_SECRET": "0123456789abcdef", "RELAY_LISTEN": "9009"}, expectErr: true},
+		{name: "Invalid Allowed MAC", env: map[string]string{"RELAY_SECRET": "0123456789abcdef", "RELAY_ALLOWED_MACS": "AA:BB:CC:DD:EE:FF, nas"}, expectErr: true},
+		{name: "Ethernet Without Interface", env: map[string]string{"RELAY_SECRET": "0123456789abcdef", "RELAY_TRANSPORT": "ethernet"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			rc, err := loadRelayConfig()
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if err == nil && (rc.Listen != "0.0.0.0:9009" || rc.Port != 9 || rc.BroadcastIP != "255.255.255.255") {
+				t.Errorf("Unexpected defaults: %+v", rc)
+			}
+		})
+	}
+}
+
+func TestParseDependsOn(t *testing.T) {
+	got, err := parseDependsOn("105, storage/agent,110/tcp=192.168.1.5:2049")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []provider.ProxmoxDependency{
+		{VMID: "105"},
+		{Name: "storage", Ready: "agent"},
+		{VMID: "110", Ready: "tcp", Address: "192.168.1.5:2049"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d dependencies, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected dependency %d to be %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+
+	if _, err := parseDependsOn("/agent"); err == nil {
+		t.Error("Expected error for a dependency without a guest, got nil")
+	}
+}
+
+func splitEnv(s string) []string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '=' {
+			return []string{s[:i], s[i+1:]}
+		}
+	}
+	return []string{s, ""}
+}
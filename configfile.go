@@ -0,0 +1,495 @@
+package main
+
+import (
+	"fmt"
+	"mop/provider"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the schema of the YAML config file. See README.md for an example.
+type fileConfig struct {
+	ProxyHost string `yaml:"proxy_host"`
+	Retry     struct {
+		InitialDelay time.Duration `yaml:"initial_delay"`
+		MaxDelay     time.Duration `yaml:"max_delay"`
+		Multiplier   float64       `yaml:"multiplier"`
+		Jitter       float64       `yaml:"jitter"`
+	} `yaml:"retry"`
+	WakeTimeout         time.Duration          `yaml:"wake_timeout"`
+	DialTimeout         time.Duration          `yaml:"dial_timeout"`
+	FastPathTimeout     time.Duration          `yaml:"fast_path_timeout"`
+	KnownUpTTL          time.Duration          `yaml:"known_up_ttl"`
+	IdleShutdownMinutes int                    `yaml:"idle_shutdown_minutes"`
+	ShutdownTimeout     time.Duration          `yaml:"shutdown_timeout"`
+	Machines            map[string]fileMachine `yaml:"machines"`
+	Routes              []fileRoute            `yaml:"routes"`
+}
+
+// fileMachine is a machine entry in the config file, keyed by its name.
+type fileMachine struct {
+	Host         string `yaml:"host"`
+	WakeupMethod string `yaml:"wakeup_method"`
+	WOL          struct {
+		MAC            string        `yaml:"mac"`
+		BroadcastIP    string        `yaml:"broadcast_ip"`
+		SecureOn       string        `yaml:"secureon"`
+		Interface      string        `yaml:"interface"`
+		Port           int           `yaml:"port"`
+		Transport      string        `yaml:"transport"`
+		BurstCount     int           `yaml:"burst_count"`
+		BurstInterval  time.Duration `yaml:"burst_interval"`
+		ResendInterval time.Duration `yaml:"resend_interval"`
+		Relay          string        `yaml:"relay"`
+		RelayProtocol  string        `yaml:"relay_protocol"`
+		RelaySecret    string        `yaml:"relay_secret"`
+		SleepCommand   string        `yaml:"sleep_command"`
+	} `yaml:"wol"`
+	Proxmox struct {
+		APIURL            string           `yaml:"api_url"`
+		Node              string           `yaml:"node"`
+		VMID              string           `yaml:"vmid"`
+		Name              string           `yaml:"name"`
+		Tag               string           `yaml:"tag"`
+		Token             string           `yaml:"token"`
+		Username          string           `yaml:"username"`
+		Password          string           `yaml:"password"`
+		TOTPSecret        string           `yaml:"totp_secret"`
+		Type              string           `yaml:"type"`
+		Insecure          bool             `yaml:"insecure"`
+		CAFile            string           `yaml:"ca_file"`
+		Fingerprints      []string         `yaml:"fingerprints"`
+		Timeout           time.Duration    `yaml:"timeout"`
+		TaskTimeout       time.Duration    `yaml:"task_timeout"`
+		SleepAction       string           `yaml:"sleep_action"`
+		AgentTimeout      time.Duration    `yaml:"agent_timeout"`
+		DiscoverHost      bool             `yaml:"discover_host"`
+		AddressCIDRs      []string         `yaml:"address_cidrs"`
+		AddressInterfaces []string         `yaml:"address_interfaces"`
+		DependsOn         []fileDependency `yaml:"depends_on"`
+	} `yaml:"proxmox"`
+}
+
+// fileDependency is a guest a Proxmox machine depends on.
+type fileDependency struct {
+	VMID    string        `yaml:"vmid"`
+	Name    string        `yaml:"name"`
+	Tag     string        `yaml:"tag"`
+	Type    string        `yaml:"type"`
+	Ready   string        `yaml:"ready"`
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// fileRoute is a route entry in the config file.
+type fileRoute struct {
+	ProxyHost  string `yaml:"proxy_host"`
+	ProxyPort  int    `yaml:"proxy_port"`
+	Machine    string `yaml:"machine"`
+	TargetPort int    `yaml:"target_port"`
+	Readiness  struct {
+		Type       string `yaml:"type"`
+		Path       string `yaml:"path"`
+		Status     int    `yaml:"status"`
+		TLS        bool   `yaml:"tls"`
+		ServerName string `yaml:"server_name"`
+		Insecure   bool   `yaml:"insecure"`
+		Send       string `yaml:"send"`
+		Expect     string `yaml:"expect"`
+	} `yaml:"readiness"`
+}
+
+// fileGlobalKeys maps the environment variable name of a global setting to its
+// key in the config file.
+var fileGlobalKeys = map[string]string{
+	"RETRY_INITIAL_DELAY":   "retry.initial_delay",
+	"RETRY_MAX_DELAY":       "retry.max_delay",
+	"RETRY_MULTIPLIER":      "retry.multiplier",
+	"RETRY_JITTER":          "retry.jitter",
+	"WAKE_TIMEOUT":          "wake_timeout",
+	"DIAL_TIMEOUT":          "dial_timeout",
+	"FAST_PATH_TIMEOUT":     "fast_path_timeout",
+	"KNOWN_UP_TTL":          "known_up_ttl",
+	"IDLE_SHUTDOWN_MINUTES": "idle_shutdown_minutes",
+	"SHUTDOWN_TIMEOUT":      "shutdown_timeout",
+}
+
+// fileMachineKeys maps the environment variable name of a machine setting to its
+// key below machines.<name> in the config file.
+var fileMachineKeys = map[string]string{
+	"TARGET_HOST":                "host",
+	"WAKEUP_METHOD":              "wakeup_method",
+	"TARGET_MAC":                 "wol.mac",
+	"TARGET_BROADCAST_IP":        "wol.broadcast_ip",
+	"TARGET_SECUREON":            "wol.secureon",
+	"WOL_INTERFACE":              "wol.interface",
+	"WOL_PORT":                   "wol.port",
+	"WOL_TRANSPORT":              "wol.transport",
+	"WOL_BURST_COUNT":            "wol.burst_count",
+	"WOL_BURST_INTERVAL":         "wol.burst_interval",
+	"WOL_RESEND_INTERVAL":        "wol.resend_interval",
+	"WOL_RELAY":                  "wol.relay",
+	"WOL_RELAY_PROTOCOL":         "wol.relay_protocol",
+	"WOL_RELAY_SECRET":           "wol.relay_secret",
+	"SLEEP_COMMAND":              "wol.sleep_command",
+	"PROXMOX_API_URL":            "proxmox.api_url",
+	"PROXMOX_NODE":               "proxmox.node",
+	"PROXMOX_VMID":               "proxmox.vmid",
+	"PROXMOX_NAME":               "proxmox.name",
+	"PROXMOX_TAG":                "proxmox.tag",
+	"PROXMOX_USERNAME":           "proxmox.username",
+	"PROXMOX_PASSWORD":           "proxmox.password",
+	"PROXMOX_TOTP_SECRET":        "proxmox.totp_secret",
+	"PROXMOX_TOKEN":              "proxmox.token",
+	"PROXMOX_TYPE":               "proxmox.type",
+	"PROXMOX_INSECURE":           "proxmox.insecure",
+	"PROXMOX_CA_FILE":            "proxmox.ca_file",
+	"PROXMOX_FINGERPRINTS":       "proxmox.fingerprints",
+	"PROXMOX_TIMEOUT":            "proxmox.timeout",
+	"PROXMOX_TASK_TIMEOUT":       "proxmox.task_timeout",
+	"PROXMOX_AGENT_TIMEOUT":      "proxmox.agent_timeout",
+	"PROXMOX_SLEEP_ACTION":       "proxmox.sleep_action",
+	"PROXMOX_DISCOVER_HOST":      "proxmox.discover_host",
+	"PROXMOX_ADDRESS_CIDRS":      "proxmox.address_cidrs",
+	"PROXMOX_ADDRESS_INTERFACES": "proxmox.address_interfaces",
+	"PROXMOX_DEPENDS_ON":         "proxmox.depends_on",
+}
+
+// fileProbeKeys maps the environment variable name of a probe setting to its
+// key below routes[<index>] in the config file.
+var fileProbeKeys = map[string]string{
+	"READINESS_PROBE":   "readiness.type",
+	"PROBE_HTTP_PATH":   "readiness.path",
+	"PROBE_HTTP_STATUS": "readiness.status",
+	"PROBE_TLS":         "readiness.tls",
+	"PROBE_SERVER_NAME": "readiness.server_name",
+	"PROBE_INSECURE":    "readiness.insecure",
+	"PROBE_SEND":        "readiness.send",
+	"PROBE_EXPECT":      "readiness.expect",
+}
+
+// envReference matches ${VAR} and ${VAR:-default} references in the config file.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolateEnv replaces environment variable references in the scalar values
+// below node, so comments and keys are left alone and values need no escaping.
+// A reference to an unset variable without a default is an error.
+func interpolateEnv(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var missing string
+		value := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			match := envReference.FindStringSubmatch(ref)
+			if value, ok := os.LookupEnv(match[1]); ok {
+				return value
+			}
+			if match[2] != "" {
+				return match[3]
+			}
+			if missing == "" {
+				missing = match[1]
+			}
+			return ""
+		})
+		if missing != "" {
+			return fmt.Errorf("line %d: environment variable %s is not set", node.Line, missing)
+		}
+		if value != node.Value {
+			node.Value = value
+			// A plain value is typed by what it holds, e.g. port: ${PORT}
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateEnv(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := interpolateEnv(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkKnownFields reports a key below node that has no field in t, like the
+// decoder's KnownFields, which decoding a yaml.Node can't enable.
+func checkKnownFields(node *yaml.Node, t reflect.Type) error {
+	switch {
+	case node.Kind == yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := checkKnownFields(child, t); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fields[name] = t.Field(i).Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			field, ok := fields[key.Value]
+			if !ok {
+				return fmt.Errorf("line %d: field %s not found in type %s", key.Line, key.Value, t)
+			}
+			if err := checkKnownFields(node.Content[i+1], field); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := checkKnownFields(node.Content[i], t.Elem()); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, child := range node.Content {
+			if err := checkKnownFields(child, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadConfigFile loads configuration from a YAML file. Environment variables
+// override values from the file: the global settings use their usual names and
+// machine settings use the machine's prefix, e.g. NAS_TARGET_MAC.
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := checkKnownFields(&root, reflect.TypeOf(fileConfig{})); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := interpolateEnv(&root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	defaults := defaultConfig()
+	fc := fileConfig{
+		ProxyHost:       "0.0.0.0",
+		WakeTimeout:     defaults.WakeTimeout,
+		DialTimeout:     defaults.DialTimeout,
+		FastPathTimeout: defaults.FastTimeout,
+		KnownUpTTL:      defaults.KnownUpTTL,
+		ShutdownTimeout: defaults.ShutdownTimeout,
+	}
+	fc.Retry.InitialDelay = defaults.Backoff.InitialDelay
+	fc.Retry.MaxDelay = defaults.Backoff.MaxDelay
+	fc.Retry.Multiplier = defaults.Backoff.Multiplier
+	fc.Retry.Jitter = defaults.Backoff.Jitter
+
+	if root.Kind != 0 {
+		if err := root.Decode(&fc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	cfg := &Config{
+		Backoff: BackoffConfig{
+			InitialDelay: fc.Retry.InitialDelay,
+			MaxDelay:     fc.Retry.MaxDelay,
+			Multiplier:   fc.Retry.Multiplier,
+			Jitter:       fc.Retry.Jitter,
+		},
+		WakeTimeout:     fc.WakeTimeout,
+		DialTimeout:     fc.DialTimeout,
+		FastTimeout:     fc.FastPathTimeout,
+		KnownUpTTL:      fc.KnownUpTTL,
+		IdleTimeout:     time.Duration(fc.IdleShutdownMinutes) * time.Minute,
+		ShutdownTimeout: fc.ShutdownTimeout,
+	}
+
+	// Environment overrides for the global settings
+	fc.ProxyHost = getEnv("PROXY_HOST", fc.ProxyHost)
+	if err := applyGlobalEnv(cfg); err != nil {
+		return nil, err
+	}
+	err = validateGlobal(cfg, func(key string) string {
+		return fileGlobalKeys[key]
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(fc.Machines) == 0 {
+		return nil, fmt.Errorf("%s: machines must contain at least one machine", path)
+	}
+
+	names := make([]string, 0, len(fc.Machines))
+	for name := range fc.Machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fm := fc.Machines[name]
+		mc := MachineConfig{
+			Name:                     name,
+			TargetHost:               fm.Host,
+			TargetMAC:                fm.WOL.MAC,
+			TargetBroadcastIP:        fm.WOL.BroadcastIP,
+			TargetSecureOn:           fm.WOL.SecureOn,
+			WOLInterface:             fm.WOL.Interface,
+			WOLPort:                  fm.WOL.Port,
+			WOLTransport:             strings.ToLower(fm.WOL.Transport),
+			WOLBurstCount:            fm.WOL.BurstCount,
+			WOLBurstInterval:         fm.WOL.BurstInterval,
+			WOLResendInterval:        fm.WOL.ResendInterval,
+			WOLRelay:                 fm.WOL.Relay,
+			WOLRelayProtocol:         strings.ToLower(fm.WOL.RelayProtocol),
+			WOLRelaySecret:           fm.WOL.RelaySecret,
+			ProxmoxAPIURL:            fm.Proxmox.APIURL,
+			ProxmoxNode:              fm.Proxmox.Node,
+			ProxmoxVMID:              fm.Proxmox.VMID,
+			ProxmoxName:              fm.Proxmox.Name,
+			ProxmoxTag:               fm.Proxmox.Tag,
+			ProxmoxToken:             fm.Proxmox.Token,
+			ProxmoxUsername:          fm.Proxmox.Username,
+			ProxmoxPassword:          fm.Proxmox.Password,
+			ProxmoxTOTPSecret:        fm.Proxmox.TOTPSecret,
+			ProxmoxType:              fm.Proxmox.Type,
+			ProxmoxInsecure:          fm.Proxmox.Insecure,
+			ProxmoxCAFile:            fm.Proxmox.CAFile,
+			ProxmoxFingerprints:      fm.Proxmox.Fingerprints,
+			ProxmoxTimeout:           fm.Proxmox.Timeout,
+			ProxmoxTaskTimeout:       fm.Proxmox.TaskTimeout,
+			ProxmoxSleepAction:       strings.ToLower(fm.Proxmox.SleepAction),
+			ProxmoxAgentTimeout:      fm.Proxmox.AgentTimeout,
+			ProxmoxDiscoverHost:      fm.Proxmox.DiscoverHost,
+			ProxmoxAddressCIDRs:      fm.Proxmox.AddressCIDRs,
+			ProxmoxAddressInterfaces: fm.Proxmox.AddressInterfaces,
+			WakeupMethod:             strings.ToLower(fm.WakeupMethod),
+			SleepCommand:             fm.WOL.SleepCommand,
+		}
+		for _, fd := range fm.Proxmox.DependsOn {
+			mc.ProxmoxDependsOn = append(mc.ProxmoxDependsOn, provider.ProxmoxDependency{
+				VMID:    fd.VMID,
+				Name:    fd.Name,
+				Tag:     fd.Tag,
+				Type:    fd.Type,
+				Ready:   strings.ToLower(fd.Ready),
+				Address: fd.Address,
+				Timeout: fd.Timeout,
+			})
+		}
+		if mc.WakeupMethod == "" {
+			mc.WakeupMethod = "wol"
+		}
+		if mc.TargetBroadcastIP == "" {
+			mc.TargetBroadcastIP = "255.255.255.255"
+		}
+		if mc.WOLPort == 0 {
+			mc.WOLPort = 9
+		}
+		if mc.WOLTransport == "" {
+			mc.WOLTransport = provider.WOLTransportUDP
+		}
+		if mc.WOLBurstCount == 0 {
+			mc.WOLBurstCount = 1
+		}
+		if mc.WOLRelayProtocol == "" {
+			mc.WOLRelayProtocol = provider.RelayProtocolTCP
+		}
+		if mc.ProxmoxType == "" {
+			mc.ProxmoxType = "qemu"
+		}
+		if err := applyMachineEnv(&mc, envPrefix(name)); err != nil {
+			return nil, err
+		}
+		if mc.TargetHost == "" && !mc.discoversHost() {
+			mc.TargetHost = name
+		}
+
+		err := validateMachine(mc, cfg.IdleTimeout, func(key string) string {
+			return fmt.Sprintf("machines.%s.%s", name, fileMachineKeys[key])
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cfg.Machines = append(cfg.Machines, mc)
+	}
+
+	if len(fc.Routes) == 0 {
+		return nil, fmt.Errorf("%s: routes must contain at least one route", path)
+	}
+
+	ports := make(map[string]int)
+	for i, fr := range fc.Routes {
+		key := fmt.Sprintf("routes[%d]", i)
+		if fr.ProxyHost == "" {
+			fr.ProxyHost = fc.ProxyHost
+		}
+		if fr.ProxyPort < 1 || fr.ProxyPort > 65535 {
+			return nil, fmt.Errorf("%s: %s.proxy_port must be between 1 and 65535, got %d", path, key, fr.ProxyPort)
+		}
+		if fr.TargetPort < 1 || fr.TargetPort > 65535 {
+			return nil, fmt.Errorf("%s: %s.target_port must be between 1 and 65535, got %d", path, key, fr.TargetPort)
+		}
+		if _, ok := fc.Machines[fr.Machine]; !ok {
+			return nil, fmt.Errorf("%s: %s.machine refers to unknown machine %q", path, key, fr.Machine)
+		}
+
+		probe := ProbeConfig{
+			Type:       strings.ToLower(fr.Readiness.Type),
+			Path:       fr.Readiness.Path,
+			Status:     fr.Readiness.Status,
+			TLS:        fr.Readiness.TLS,
+			ServerName: fr.Readiness.ServerName,
+			Insecure:   fr.Readiness.Insecure,
+			Send:       fr.Readiness.Send,
+			Expect:     fr.Readiness.Expect,
+		}
+		if probe.Type == "" {
+			probe.Type = "tcp"
+		}
+		if probe.Path == "" {
+			probe.Path = "/"
+		}
+		if probe.Status == 0 {
+			probe.Status = 200
+		}
+		err := validateProbe(probe, func(name string) string {
+			return fmt.Sprintf("%s.%s", key, fileProbeKeys[name])
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		listenAddr := fmt.Sprintf("%s:%d", fr.ProxyHost, fr.ProxyPort)
+		if other, ok := ports[listenAddr]; ok {
+			return nil, fmt.Errorf("%s: %s.proxy_port %d is already used by routes[%d]", path, key, fr.ProxyPort, other)
+		}
+		ports[listenAddr] = i
+
+		cfg.Routes = append(cfg.Routes, RouteConfig{
+			ProxyHost:  fr.ProxyHost,
+			ProxyPort:  fr.ProxyPort,
+			Machine:    fr.Machine,
+			TargetPort: fr.TargetPort,
+			Probe:      probe,
+		})
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes content to a config file in a temporary directory.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mop.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Setenv("PVE_TOKEN", "user@pam!token=secret")
+	t.Setenv("NAS_TARGET_MAC", "11:22:33:44:55:66")
+	t.Setenv("WAKE_TIMEOUT", "90s")
+
+	path := writeConfigFile(t, `
+retry:
+  initial_delay: 500ms
+  multiplier: 1.5
+wake_timeout: 5m
+idle_shutdown_minutes: 30
+machines:
+  nas:
+    host: 192.168.1.10
+    wol:
+      mac: AA:BB:CC:DD:EE:FF
+      sleep_command: ssh nas systemctl suspend
+  gpu-box:
+    wakeup_method: proxmox
+    proxmox:
+      api_url: https://pve:8006/api2/json
+      node: pve1
+      vmid: "110"
+      token: ${PVE_TOKEN}
+      type: ${PVE_TYPE:-lxc}
+routes:
+  - proxy_port: 2222
+    machine: nas
+    target_port: 22
+  - proxy_port: 8443
+    machine: nas
+    target_port: 443
+  - proxy_port: 2223
+    machine: gpu-box
+    target_port: 22
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cfg.WakeTimeout != 90*time.Second {
+		t.Errorf("Expected WAKE_TIMEOUT to override the file, got %v", cfg.WakeTimeout)
+	}
+	expectedBackoff := BackoffConfig{InitialDelay: 500 * time.Millisecond, MaxDelay: 15 * time.Second, Multiplier: 1.5, Jitter: 0.2}
+	if cfg.Backoff != expectedBackoff {
+		t.Errorf("Expected backoff %+v, got %+v", expectedBackoff, cfg.Backoff)
+	}
+	if cfg.IdleTimeout.Minutes() != 30 {
+		t.Errorf("Expected idle timeout of 30 minutes, got %v", cfg.IdleTimeout)
+	}
+	if len(cfg.Machines) != 2 || len(cfg.Routes) != 3 {
+		t.Fatalf("Expected 2 machines and 3 routes, got %d and %d", len(cfg.Machines), len(cfg.Routes))
+	}
+
+	gpu, nas := cfg.Machines[0], cfg.Machines[1]
+	if gpu.TargetHost != "gpu-box" || gpu.ProxmoxToken != "user@pam!token=secret" || gpu.ProxmoxType != "lxc" {
+		t.Errorf("Unexpected gpu-box machine: %+v", gpu)
+	}
+	if nas.TargetMAC != "11:22:33:44:55:66" || nas.WakeupMethod != "wol" {
+		t.Errorf("Unexpected nas machine: %+v", nas)
+	}
+	if cfg.Routes[2].ProxyHost != "0.0.0.0" || cfg.Routes[2].Machine != "gpu-box" {
+		t.Errorf("Unexpected route: %+v", cfg.Routes[2])
+	}
+}
+
+func TestLoadConfigFileInterpolation(t *testing.T) {
+	t.Setenv("NAS_SLEEP", `ssh nas "systemctl suspend" # now: please`)
+	t.Setenv("NAS_PORT", "2222")
+
+	path := writeConfigFile(t, `
+# ${MOP_TEST_UNSET_COMMENT} is only mentioned here
+machines:
+  nas:
+    host: 192.168.1.10 # ${MOP_TEST_UNSET_COMMENT}
+    wol:
+      mac: AA:BB:CC:DD:EE:FF
+      sleep_command: ${NAS_SLEEP}
+routes:
+  - proxy_port: ${NAS_PORT}
+    machine: nas
+    target_port: ${NAS_TARGET_PORT:-22}
+`)
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if expected := os.Getenv("NAS_SLEEP"); cfg.Machines[0].SleepCommand != expected {
+		t.Errorf("Expected sleep command %q, got %q", expected, cfg.Machines[0].SleepCommand)
+	}
+	if cfg.Machines[0].TargetHost != "192.168.1.10" {
+		t.Errorf("Expected host 192.168.1.10, got %q", cfg.Machines[0].TargetHost)
+	}
+	if cfg.Routes[0].ProxyPort != 2222 || cfg.Routes[0].TargetPort != 22 {
+		t.Errorf("Unexpected route: %+v", cfg.Routes[0])
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr string
+	}{
+		{
+			name: "Missing MAC",
+			content: `
+machines:
+  nas: {}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: "machines.nas.wol.mac is required when machines.nas.wakeup_method is 'wol'",
+		},
+		{
+			name: "Idle Shutdown Without Sleep Command",
+			content: `
+idle_shutdown_minutes: 30
+machines:
+  nas:
+    wol: {mac: "AA:BB:CC:DD:EE:FF"}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: "machines.nas.wol.sleep_command is required when idle shutdown is enabled",
+		},
+		{
+			name: "Invalid SecureOn Password",
+			content: `
+machines:
+  nas:
+    wol: {mac: "AA:BB:CC:DD:EE:FF", secureon: "1.2.3"}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: `machines.nas.wol.secureon: invalid SecureOn password "1.2.3"`,
+		},
+		{
+			name: "Unknown WOL Relay Protocol",
+			content: `
+machines:
+  nas:
+    wol: {mac: "AA:BB:CC:DD:EE:FF", relay: "192.168.1.2:9009", relay_protocol: http, relay_secret: 0123456789abcdef}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: `machines.nas.wol.relay_protocol has unknown protocol "http", expected tcp or udp`,
+		},
+		{
+			name: "Unknown WOL Transport",
+			content: `
+machines:
+  nas:
+    wol: {mac: "AA:BB:CC:DD:EE:FF", interface: eth0, transport: ipx}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: `machines.nas.wol.transport has unknown transport "ipx", expected udp or ethernet`,
+		},
+		{
+			name: "IPv6 WOL Interface",
+			content: `
+machines:
+  nas:
+    wol: {mac: "AA:BB:CC:DD:EE:FF", interface: "fd00::10", transport: ethernet}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+`,
+			expectedErr: `machines.nas.wol.interface must be an interface name, an IPv4 address or "all", got fd00::10`,
+		},
+		{
+			name: "Missing Proxmox VMID",
+			content: `
+machines:
+  pve:
+    wakeup_method: proxmox
+    proxmox: {api_url: https://pve, node: pve1, token: t}
+routes:
+  - {proxy_port: 2222, machine: pve, target_port: 22}
+`,
+			expectedErr: "machines.pve.proxmox.vmid, machines.pve.proxmox.name or machines.pve.proxmox.tag is required",
+		},
+		{
+			name: "Hibernate Container",
+			content: `
+machines:
+  ct:
+    wakeup_method: proxmox
+    proxmox: {api_url: https://pve, node: pve1, vmid: "101", token: t, type: lxc, sleep_action: hibernate}
+routes:
+  - {proxy_port: 2222, machine: ct, target_port: 22}
+`,
+			expectedErr: `machines.ct.proxmox.sleep_action "hibernate" is only supported for qemu VMs`,
+		},
+		{
+			name: "Agent Timeout For Container",
+			content: `
+machines:
+  ct:
+    wakeup_method: proxmox
+    proxmox: {api_url: https://pve, vmid: "101", token: t, type: lxc, agent_timeout: 1m}
+routes:
+  - {proxy_port: 2222, machine: ct, target_port: 22}
+`,
+			expectedErr: "machines.ct.proxmox.agent_timeout is only supported for qemu VMs",
+		},
+		{
+			name: "Invalid Fingerprint",
+			content: `
+machines:
+  pve:
+    wakeup_method: proxmox
+    proxmox: {api_url: https://pve, vmid: "100", token: t, fingerprints: ["AB:CD"]}
+routes:
+  - {proxy_port: 2222, machine: pve, target_port: 22}
+`,
+			expectedErr: `machines.pve.proxmox.fingerprints: invalid SHA-256 fingerprint "AB:CD"`,
+		},
+		{
+			name: "Insecure With CA File",
+			content: `
+machines:
+  pve:
+    wakeup_method: proxmox
+    proxmox: {api_url: https://pve, vmid: "100", token: t, insecure: true, ca_file: /etc/pve/pve-root-ca.pem}
+routes:
+  - {proxy_port: 2222, machine: pve, target_port: 22}
+`,
+			expectedErr: "machines.pve.proxmox.insecure cannot be combined with machines.pve.proxmox.ca_file or machines.pve.proxmox.fingerprints",
+		},
+		{
+			name: "Unknown Dependency Readiness",
+			content: `
+machines:
+  app:
+    wakeup_method: proxmox
+    proxmox:
+      api_url: https://pve
+      vmid: "110"
+      token: t
+      depends_on:
+        - {vmid: "105"}
+        - {name: db, ready: ping}
+routes:
+  - {proxy_port: 2222, machine: app, target_port: 22}
+`,
+			expectedErr: `machines.app.proxmox.depends_on[1]: unknown ready check "ping", expected running, agent or tcp`,
+		},
+		{
+			name: "Unknown Machine",
+			content: `
+machines:
+  nas: {wakeup_method: noop}
+routes:
+  - {proxy_port: 2222, machine: nas, target_port: 22}
+  - {proxy_port: 2223, machine: gpu, target_port: 22}
+`,
+			expectedErr: `routes[1].machine refers to unknown machine "gpu"`,
+		},
+		{
+			name: "Invalid Port",
+			content: `
+machines:
+  nas: {wakeup_method: noop}
+routes:
+  - {proxy_port: 70000, machine: nas, target_port: 22}
+`,
+			expectedErr: "routes[0].proxy_port must be between 1 and 65535",
+		},
+		{
+			name: "Expect Probe Without Pattern",
+			content: `
+machines:
+  nas: {wakeup_method: noop}
+routes:
+  - proxy_port: 2222
+    machine: nas
+    target_port: 22
+    readiness: {type: expect, send: "PING"}
+`,
+			expectedErr: "routes[0].readiness.expect is required when routes[0].readiness.type is 'expect'",
+		},
+		{
+			name: "Invalid Jitter",
+			content: `
+retry: {jitter: 1.5}
+`,
+			expectedErr: "retry.jitter must be between 0 and 1",
+		},
+		{
+			name: "Invalid Duration",
+			content: `
+wake_timeout: soon
+`,
+			expectedErr: "cannot unmarshal",
+		},
+		{
+			name: "Unknown Key",
+			content: `
+machines:
+  nas: {wakeup_method: noop, mac: AA:BB:CC:DD:EE:FF}
+`,
+			expectedErr: "field mac not found",
+		},
+		{
+			name: "Unset Variable",
+			content: `
+machines:
+  nas:
+    host: ${MOP_TEST_UNSET_HOST}
+`,
+			expectedErr: "line 4: environment variable MOP_TEST_UNSET_HOST is not set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.content)
+			_, err := loadConfig(path)
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.HasPrefix(err.Error(), path+": ") {
+				t.Errorf("Expected error to start with the file path, got %q", err)
+			}
+			if !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.expectedErr, err)
+			}
+		})
+	}
+}
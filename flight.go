@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightCall is a single in-flight execution shared by every caller of the same key.
+type flightCall[T any] struct {
+	done    chan struct{}
+	result  T
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent calls with the same key into a single execution.
+// The first caller starts the function; callers arriving while it is running wait
+// for and share its result. Once the call finishes the key is forgotten, so the next
+// caller starts a fresh execution.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// Do runs fn for key, or joins the execution already in flight for that key.
+//
+// A caller whose ctx is cancelled stops waiting and returns ctx.Err(), while the
+// execution carries on for the remaining callers. The ctx passed to fn is only
+// cancelled once every caller has stopped waiting.
+func (g *flightGroup[T]) Do(ctx context.Context, key string, fn func(context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.result, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody is waiting any more: abandon the execution, and let the
+			// next caller start a fresh one instead of joining a cancelled one.
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// run executes fn for a call and publishes its result.
+func (g *flightGroup[T]) run(ctx context.Context, key string, c *flightCall[T], fn func(context.Context) (T, error)) {
+	defer c.cancel()
+	c.result, c.err = fn(ctx)
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCoalescesConcurrentCalls(t *testing.T) {
+	g := &flightGroup[int]{}
+	release := make(chan struct{})
+	var calls atomic.Int32
+
+	const waiters = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, waiters)
+	for range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := g.Do(context.Background(), "target", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 0, errors.New("wake failed")
+			})
+			errs <- err
+		}()
+	}
+
+	// Give every goroutine a chance to join the in-flight call before releasing it.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 execution, got %d", got)
+	}
+	for err := range errs {
+		if err == nil || err.Error() != "wake failed" {
+			t.Errorf("Expected shared error, got %v", err)
+		}
+	}
+}
+
+func TestFlightGroupRunsAgainAfterCompletion(t *testing.T) {
+	g := &flightGroup[int]{}
+	var calls int
+	for i := range 3 {
+		result, err := g.Do(context.Background(), "target", func(ctx context.Context) (int, error) {
+			calls++
+			return calls, nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result != i+1 {
+			t.Errorf("Expected result %d, got %d", i+1, result)
+		}
+	}
+}
+
+func TestFlightGroupSeparatesKeys(t *testing.T) {
+	g := &flightGroup[struct{}]{}
+	release := make(chan struct{})
+	var calls atomic.Int32
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do(context.Background(), key, func(ctx context.Context) (struct{}, error) {
+				calls.Add(1)
+				<-release
+				return struct{}{}, nil
+			})
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected 2 executions, got %d", got)
+	}
+}
+
+func TestFlightGroupWaiterLeavesWithoutCancellingOthers(t *testing.T) {
+	g := &flightGroup[string]{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var execCtx context.Context
+
+	leaverCtx, leave := context.WithCancel(context.Background())
+	leaverErr := make(chan error, 1)
+	go func() {
+		_, err := g.Do(leaverCtx, "target", func(ctx context.Context) (string, error) {
+			execCtx = ctx
+			close(started)
+			<-release
+			return "ready", nil
+		})
+		leaverErr <- err
+	}()
+	<-started
+
+	stayerResult := make(chan string, 1)
+	go func() {
+		result, _ := g.Do(context.Background(), "target", func(ctx context.Context) (string, error) {
+			t.Error("Expected to join the call in flight")
+			return "", nil
+		})
+		stayerResult <- result
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	leave()
+	if err := <-leaverErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the leaving caller to get context.Canceled, got %v", err)
+	}
+	if execCtx.Err() != nil {
+		t.Error("Expected the execution to continue while a caller is still waiting")
+	}
+
+	close(release)
+	if got := <-stayerResult; got != "ready" {
+		t.Errorf("Expected the remaining caller to get the result, got %q", got)
+	}
+}
+
+func TestFlightGroupLastWaiterLeavingCancelsExecution(t *testing.T) {
+	g := &flightGroup[struct{}]{}
+	cancelled := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := g.Do(ctx, "target", func(ctx context.Context) (struct{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return struct{}{}, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the execution to be cancelled once nobody was waiting")
+	}
+
+	// A new caller starts a fresh execution rather than joining the cancelled one.
+	var calls int
+	g.Do(context.Background(), "target", func(ctx context.Context) (struct{}, error) {
+		calls++
+		return struct{}{}, nil
+	})
+	if calls != 1 {
+		t.Errorf("Expected a fresh execution, got %d", calls)
+	}
+}
@@ -1,3 +1,5 @@
 module mop
 
 go 1.25.3
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// idleTracker counts active client sessions for a target and calls onIdle once
+// the target has had no sessions for the configured timeout.
+type idleTracker struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	onIdle  func()
+	active  int
+	timer   *time.Timer
+}
+
+// newIdleTracker creates an idleTracker. A timeout of zero disables idle detection.
+func newIdleTracker(timeout time.Duration, onIdle func()) *idleTracker {
+	return &idleTracker{timeout: timeout, onIdle: onIdle}
+}
+
+// Acquire registers a new session and cancels any pending idle callback.
+func (t *idleTracker) Acquire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active++
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// Release ends a session. When the last session ends the idle timer is started.
+func (t *idleTracker) Release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	if t.active > 0 || t.timeout <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		// A session may have started, or a newer timer replaced this one, after it fired.
+		if t.active > 0 || t.timer != timer {
+			t.mu.Unlock()
+			return
+		}
+		t.timer = nil
+		t.mu.Unlock()
+
+		t.onIdle()
+	})
+	t.timer = timer
+}
+
+// Active returns the number of sessions currently in progress.
+func (t *idleTracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleTrackerFiresAfterLastSession(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(20*time.Millisecond, func() { fired <- struct{}{} })
+
+	tracker.Acquire()
+	tracker.Acquire()
+	tracker.Release()
+
+	select {
+	case <-fired:
+		t.Fatal("Idle callback fired while a session was still active")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tracker.Release()
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("Idle callback did not fire after the last session ended")
+	}
+}
+
+func TestIdleTrackerCancelledByNewSession(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(30*time.Millisecond, func() { fired <- struct{}{} })
+
+	tracker.Acquire()
+	tracker.Release()
+	tracker.Acquire()
+
+	select {
+	case <-fired:
+		t.Fatal("Idle callback fired after a new session started")
+	case <-time.After(80 * time.Millisecond):
+	}
+
+	if got := tracker.Active(); got != 1 {
+		t.Errorf("Expected 1 active session, got %d", got)
+	}
+}
+
+func TestIdleTrackerDisabled(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(0, func() { fired <- struct{}{} })
+
+	tracker.Acquire()
+	tracker.Release()
+
+	select {
+	case <-fired:
+		t.Fatal("Idle callback fired with idle detection disabled")
+	case <-time.After(30 * time.Millisecond):
+	}
+}
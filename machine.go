@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mop/provider"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// sleepTimeout bounds putting an idle machine to sleep, so a hanging sleep
+// command or API call is given up on instead of piling up.
+const sleepTimeout = 5 * time.Minute
+
+// machine is a wakeable target shared by every route pointing at it.
+type machine struct {
+	name       string
+	provider   provider.WakeupProvider
+	idle       *idleTracker
+	wakes      flightGroup[provider.WakeResult]
+	readiness  flightGroup[struct{}]
+	knownUpTTL time.Duration
+
+	// resendInterval repeats a wake while waiting for readiness, 0 disables it
+	resendInterval time.Duration
+
+	// sleepMu is held while the machine is put to sleep, so a client arriving
+	// meanwhile wakes it afterwards instead of finding it going down.
+	sleepMu sync.Mutex
+
+	mu     sync.Mutex
+	host   string               // configured, or discovered by the last wake
+	lastUp map[string]time.Time // target address -> last time a connection to it succeeded
+}
+
+// newMachine creates the wakeup provider and idle tracker for a machine.
+func newMachine(mc MachineConfig, cfg *Config) (*machine, error) {
+	var wakeupProvider provider.WakeupProvider
+	switch mc.WakeupMethod {
+	case "wol":
+		wakeupProvider = &provider.WOLProvider{
+			TargetMAC:         mc.TargetMAC,
+			TargetBroadcastIP: mc.TargetBroadcastIP,
+			SecureOn:          mc.TargetSecureOn,
+			Interface:         mc.WOLInterface,
+			Port:              mc.WOLPort,
+			Transport:         mc.WOLTransport,
+			BurstCount:        mc.WOLBurstCount,
+			BurstInterval:     mc.WOLBurstInterval,
+			Relay:             mc.WOLRelay,
+			RelayProtocol:     mc.WOLRelayProtocol,
+			RelaySecret:       mc.WOLRelaySecret,
+			SleepCommand:      mc.SleepCommand,
+		}
+	case "proxmox":
+		p := &provider.ProxmoxProvider{
+			APIURL:       mc.ProxmoxAPIURL,
+			Node:         mc.ProxmoxNode,
+			VMID:         mc.ProxmoxVMID,
+			Name:         mc.ProxmoxName,
+			Tag:          mc.ProxmoxTag,
+			Token:        mc.ProxmoxToken,
+			Username:     mc.ProxmoxUsername,
+			Password:     mc.ProxmoxPassword,
+			TOTPSecret:   mc.ProxmoxTOTPSecret,
+			Type:         mc.ProxmoxType,
+			Insecure:     mc.ProxmoxInsecure,
+			CAFile:       mc.ProxmoxCAFile,
+			Fingerprints: mc.ProxmoxFingerprints,
+			Timeout:      mc.ProxmoxTimeout,
+			TaskTimeout:  mc.ProxmoxTaskTimeout,
+			SleepAction:  mc.ProxmoxSleepAction,
+			AgentTimeout: mc.ProxmoxAgentTimeout,
+
+			DiscoverAddress:   mc.ProxmoxDiscoverHost,
+			AddressInterfaces: mc.ProxmoxAddressInterfaces,
+			DependsOn:         mc.ProxmoxDependsOn,
+		}
+		for _, cidr := range mc.ProxmoxAddressCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address CIDR for machine %s: %w", mc.Name, err)
+			}
+			p.AddressCIDRs = append(p.AddressCIDRs, prefix)
+		}
+		// Guests addressed by name or tag are looked up now, so that a
+		// missing or ambiguous match stops mop at startup. If the API can't
+		// be reached, the guest is looked up on the first wake instead.
+		if p.Name != "" || p.Tag != "" {
+			var matchErr *provider.GuestMatchError
+			if err := p.Resolve(context.Background()); errors.As(err, &matchErr) {
+				return nil, fmt.Errorf("machine %s: %w", mc.Name, err)
+			} else if err != nil {
+				log.Printf("Failed to look up the Proxmox guest of machine %s, retrying on the first wake: %v", mc.Name, err)
+			}
+		}
+		wakeupProvider = p
+	case "noop":
+		wakeupProvider = &provider.NoopProvider{}
+	default:
+		return nil, fmt.Errorf("unknown wakeup method for machine %s: %s", mc.Name, mc.WakeupMethod)
+	}
+
+	m := &machine{
+		name:       mc.Name,
+		host:       mc.TargetHost,
+		provider:   wakeupProvider,
+		knownUpTTL: cfg.KnownUpTTL,
+		lastUp:     make(map[string]time.Time),
+	}
+	if mc.WakeupMethod == "wol" {
+		m.resendInterval = mc.WOLResendInterval
+	}
+	m.idle = newIdleTracker(cfg.IdleTimeout, func() {
+		m.sleep(max(sleepTimeout, mc.ProxmoxTaskTimeout))
+	})
+	return m, nil
+}
+
+// sleep puts the idle machine to sleep, giving up after timeout. It is skipped
+// if a client arrived since the machine became idle.
+func (m *machine) sleep(timeout time.Duration) {
+	m.sleepMu.Lock()
+	defer m.sleepMu.Unlock()
+	if m.idle.Active() > 0 {
+		log.Printf("Machine %s is in use again. Not putting it to sleep.", m.name)
+		return
+	}
+
+	log.Printf("No active connections to machine %s for %v. Putting it to sleep.", m.name, m.idle.timeout)
+	m.markAllDown()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := m.provider.Sleep(ctx); err != nil {
+		log.Printf("Error putting machine %s to sleep: %v", m.name, err)
+	}
+}
+
+// waitAsleep waits until a sleep in progress has finished. A client calls it
+// after acquiring its session, so no new sleep starts while it connects.
+func (m *machine) waitAsleep() {
+	m.sleepMu.Lock()
+	defer m.sleepMu.Unlock()
+}
+
+// wake calls the wakeup provider, coalescing concurrent wakes of this machine
+// coming from different routes.
+// An address discovered by the provider replaces the machine's host.
+func (m *machine) wake(ctx context.Context) (provider.WakeResult, error) {
+	result, err := m.wakes.Do(ctx, m.name, m.provider.Wake)
+	if err == nil && result.Address != "" {
+		m.setHost(result.Address)
+	}
+	return result, err
+}
+
+// resendWake repeats the wakeup every resendInterval until ctx is done, which
+// is when the readiness wait ends. A Wake-on-LAN packet can get lost, e.g. on
+// a busy Wi-Fi bridge, and nothing would tell us.
+func (m *machine) resendWake(ctx context.Context) {
+	if m.resendInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.resendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		log.Printf("Machine %s is not ready yet. Sending its wakeup again.", m.name)
+		if _, err := m.provider.Wake(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error resending wakeup to machine %s: %v", m.name, err)
+		}
+	}
+}
+
+// close releases the resources of the machine's wakeup provider, such as the
+// Proxmox provider's API connections.
+func (m *machine) close() {
+	if closer, ok := m.provider.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Error closing wakeup provider of machine %s: %v", m.name, err)
+		}
+	}
+}
+
+// currentHost returns the host the machine is reached at. It is empty while
+// the host is yet to be discovered by a wake.
+func (m *machine) currentHost() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.host
+}
+
+// setHost changes the host the machine is reached at.
+func (m *machine) setHost(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if host != m.host {
+		log.Printf("Machine %s is now reached at %s", m.name, host)
+		m.host = host
+	}
+}
+
+// waitReady wakes the machine and waits until the route's readiness probe passes.
+// Concurrent callers for the same target port and probe share a single wait,
+// even while the machine's host is being discovered. Routes probing the same
+// port differently wait on their own. A caller whose ctx is cancelled stops
+// waiting; the wait itself is only abandoned once no caller is left.
+func (m *machine) waitReady(ctx context.Context, r *route, cfg *Config) error {
+	key := fmt.Sprintf("%d %+v", r.TargetPort, r.Probe)
+	_, err := m.readiness.Do(ctx, key, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, wakeTarget(ctx, r, cfg)
+	})
+	return err
+}
+
+// markUp records a successful connection to targetAddr.
+func (m *machine) markUp(targetAddr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastUp[targetAddr] = time.Now()
+}
+
+// markDown forgets that targetAddr was up, e.g. after a failed connection.
+func (m *machine) markDown(targetAddr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.lastUp, targetAddr)
+}
+
+// markAllDown forgets every address of the machine, e.g. when putting it to sleep.
+func (m *machine) markAllDown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.lastUp)
+}
+
+// knownUp reports whether a connection to targetAddr succeeded within the known-up TTL.
+func (m *machine) knownUp(targetAddr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lastUp, ok := m.lastUp[targetAddr]
+	return ok && time.Since(lastUp) < m.knownUpTTL
+}
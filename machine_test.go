@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mop/probe"
+	"mop/provider"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingProvider is a WakeupProvider that counts how often it is called.
+// Its wakes report address as the discovered host.
+type countingProvider struct {
+	wakes   atomic.Int32
+	sleeps  atomic.Int32
+	closes  atomic.Int32
+	address string
+}
+
+func (p *countingProvider) Wake(ctx context.Context) (provider.WakeResult, error) {
+	p.wakes.Add(1)
+	return provider.WakeResult{Status: provider.WakeStarted, Address: p.address}, nil
+}
+
+func (p *countingProvider) Sleep(ctx context.Context) error {
+	p.sleeps.Add(1)
+	return nil
+}
+
+func (p *countingProvider) Close() error {
+	p.closes.Add(1)
+	return nil
+}
+
+// newTestRoute returns a route to targetAddr backed by a countingProvider.
+func newTestRoute(t *testing.T, cfg *Config, targetAddr string) (*route, *countingProvider) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		t.Fatalf("Invalid address %s: %v", targetAddr, err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	m, err := newMachine(MachineConfig{Name: "test", TargetHost: host, WakeupMethod: "noop"}, cfg)
+	if err != nil {
+		t.Fatalf("newMachine failed: %v", err)
+	}
+	p := &countingProvider{}
+	m.provider = p
+
+	return &route{
+		RouteConfig: RouteConfig{Machine: "test", TargetPort: port},
+		machine:     m,
+		probe:       &probe.TCPProbe{},
+	}, p
+}
+
+// listenTarget starts a TCP listener that accepts and holds connections.
+func listenTarget(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return listener
+}
+
+func TestMachineKnownUp(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.KnownUpTTL = 50 * time.Millisecond
+	r, _ := newTestRoute(t, cfg, "127.0.0.1:22")
+	m := r.machine
+
+	if m.knownUp("127.0.0.1:22") {
+		t.Fatal("Expected unknown target not to be known up")
+	}
+
+	m.markUp("127.0.0.1:22")
+	if !m.knownUp("127.0.0.1:22") {
+		t.Error("Expected target to be known up after markUp")
+	}
+	if m.knownUp("127.0.0.1:443") {
+		t.Error("Expected other ports not to be known up")
+	}
+
+	m.markDown("127.0.0.1:22")
+	if m.knownUp("127.0.0.1:22") {
+		t.Error("Expected target not to be known up after markDown")
+	}
+
+	m.markUp("127.0.0.1:22")
+	time.Sleep(60 * time.Millisecond)
+	if m.knownUp("127.0.0.1:22") {
+		t.Error("Expected known up status to expire after the TTL")
+	}
+}
+
+func TestConnectTargetSkipsWakeWhenReachable(t *testing.T) {
+	listener := listenTarget(t)
+	cfg := defaultConfig()
+	r, p := newTestRoute(t, cfg, listener.Addr().String())
+
+	for range 2 {
+		conn, err := connectTarget(context.Background(), r, cfg)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		conn.Close()
+	}
+
+	if got := p.wakes.Load(); got != 0 {
+		t.Errorf("Expected no wakeups for a reachable target, got %d", got)
+	}
+	if !r.machine.knownUp(r.targetAddr()) {
+		t.Error("Expected target to be known up after a successful connection")
+	}
+}
+
+func TestConnectTargetWakesWhenUnreachable(t *testing.T) {
+	listener := listenTarget(t)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := defaultConfig()
+	cfg.WakeTimeout = 100 * time.Millisecond
+	cfg.Backoff = BackoffConfig{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1}
+	r, p := newTestRoute(t, cfg, addr)
+	r.machine.markUp(addr)
+
+	if _, err := connectTarget(context.Background(), r, cfg); err == nil {
+		t.Fatal("Expected error for an unreachable target, got nil")
+	}
+	if got := p.wakes.Load(); got != 1 {
+		t.Errorf("Expected 1 wakeup, got %d", got)
+	}
+	if r.machine.knownUp(addr) {
+		t.Error("Expected unreachable target not to be known up")
+	}
+}
+
+func TestConnectTargetUsesDiscoveredHost(t *testing.T) {
+	listener := listenTarget(t)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	cfg := defaultConfig()
+	r, p := newTestRoute(t, cfg, net.JoinHostPort("", port))
+	p.address = "127.0.0.1"
+
+	conn, err := connectTarget(context.Background(), r, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn.Close()
+
+	if got := p.wakes.Load(); got != 1 {
+		t.Errorf("Expected 1 wakeup, got %d", got)
+	}
+	if host := r.machine.currentHost(); host != "127.0.0.1" {
+		t.Errorf("Expected discovered host 127.0.0.1, got %q", host)
+	}
+	if !r.machine.knownUp(listener.Addr().String()) {
+		t.Error("Expected the discovered target to be known up")
+	}
+}
+
+func TestWakeTargetResendsUntilReady(t *testing.T) {
+	listener := listenTarget(t)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := defaultConfig()
+	cfg.Backoff = BackoffConfig{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1}
+	r, p := newTestRoute(t, cfg, addr)
+	r.machine.resendInterval = 20 * time.Millisecond
+
+	// The target comes up after a few resends
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("Failed to listen on %s: %v", addr, err)
+			return
+		}
+		t.Cleanup(func() { listener.Close() })
+	}()
+
+	if err := wakeTarget(context.Background(), r, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wakes := p.wakes.Load()
+	if wakes < 3 {
+		t.Errorf("Expected the wakeup to be resent while waiting, got %d wakeups", wakes)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := p.wakes.Load(); got != wakes {
+		t.Errorf("Expected resends to stop once the target is ready, got %d more", got-wakes)
+	}
+}
+
+// probeFunc is a probe.Probe backed by a function.
+type probeFunc func(ctx context.Context, addr string, timeout time.Duration) error
+
+func (f probeFunc) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	return f(ctx, addr, timeout)
+}
+
+func TestWaitReadySeparatesProbes(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Backoff = BackoffConfig{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1}
+	cfg.WakeTimeout = 200 * time.Millisecond
+
+	// The TCP route's wait is held open until the end of the test
+	release := make(chan struct{})
+	defer close(release)
+	tcpRoute, _ := newTestRoute(t, cfg, "127.0.0.1:8080")
+	tcpRoute.probe = probeFunc(func(ctx context.Context, addr string, timeout time.Duration) error {
+		<-release
+		return nil
+	})
+	go tcpRoute.machine.waitReady(context.Background(), tcpRoute, cfg)
+	time.Sleep(20 * time.Millisecond)
+
+	// An HTTP route to the same port must run its own probe, which never passes
+	var checks atomic.Int32
+	httpRoute := &route{
+		RouteConfig: RouteConfig{Machine: "test", TargetPort: 8080, Probe: ProbeConfig{Type: "http", Path: "/health", Status: 200}},
+		machine:     tcpRoute.machine,
+		probe: probeFunc(func(ctx context.Context, addr string, timeout time.Duration) error {
+			checks.Add(1)
+			return fmt.Errorf("not ready")
+		}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := httpRoute.machine.waitReady(ctx, httpRoute, cfg); err == nil {
+		t.Error("Expected the HTTP route's own probe to time out, got nil")
+	}
+	if checks.Load() == 0 {
+		t.Error("Expected the HTTP route to run its own probe instead of joining the TCP route's wait")
+	}
+}
+
+// slowSleepProvider is a countingProvider whose Sleep blocks until release is closed.
+type slowSleepProvider struct {
+	countingProvider
+	sleeping chan struct{}
+	release  chan struct{}
+}
+
+func (p *slowSleepProvider) Sleep(ctx context.Context) error {
+	p.sleeps.Add(1)
+	close(p.sleeping)
+	<-p.release
+	return nil
+}
+
+func TestConnectTargetWaitsForSleep(t *testing.T) {
+	listener := listenTarget(t)
+	cfg := defaultConfig()
+	r, _ := newTestRoute(t, cfg, listener.Addr().String())
+	p := &slowSleepProvider{sleeping: make(chan struct{}), release: make(chan struct{})}
+	r.machine.provider = p
+
+	go r.machine.sleep(time.Second)
+	<-p.sleeping
+
+	// A client arrives while the machine is still going down
+	r.machine.idle.Acquire()
+	connected := make(chan error, 1)
+	go func() {
+		conn, err := connectTarget(context.Background(), r, cfg)
+		if err == nil {
+			conn.Close()
+		}
+		connected <- err
+	}()
+
+	select {
+	case <-connected:
+		t.Fatal("Expected the client to wait until the machine is asleep")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(p.release)
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to connect once the machine is asleep")
+	}
+
+	// The machine is in use now, so it is not put to sleep again
+	r.machine.sleep(time.Second)
+	if got := p.sleeps.Load(); got != 1 {
+		t.Errorf("Expected 1 sleep, got %d", got)
+	}
+}
+
+func TestMachineCloseClosesProvider(t *testing.T) {
+	r, p := newTestRoute(t, defaultConfig(), "127.0.0.1:22")
+	r.machine.close()
+	if got := p.closes.Load(); got != 1 {
+		t.Errorf("Expected the provider to be closed once, got %d", got)
+	}
+}
+
+func TestNewMachineProxmoxLookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		expectErr bool
+	}{
+		{name: "No Match", status: http.StatusOK, body: `{"data":[]}`, expectErr: true},
+		{name: "API Unavailable", status: http.StatusServiceUnavailable, expectErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			mc := MachineConfig{
+				Name:            "nas",
+				TargetHost:      "nas",
+				WakeupMethod:    "proxmox",
+				ProxmoxAPIURL:   server.URL + "/api2/json",
+				ProxmoxName:     "nas",
+				ProxmoxToken:    "user@pam!token=secret",
+				ProxmoxType:     "qemu",
+				ProxmoxInsecure: true,
+			}
+			_, err := newMachine(mc, defaultConfig())
+			if tt.expectErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
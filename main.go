@@ -1,243 +1,298 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"mop/provider"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
-// Config holds the application configuration, loaded from environment variables.
-type Config struct {
-	ProxyHost         string
-	ProxyPort         int
-	TargetHost        string
-	TargetPort        int
-	TargetMAC         string
-	TargetBroadcastIP string
-	ProxmoxAPIURL     string
-	ProxmoxNode       string
-	ProxmoxVMID       string
-	ProxmoxToken      string
-	ProxmoxType       string
-	ProxmoxInsecure   bool
-	WakeupMethod      string
-	ConnectionRetries int
-	RetryDelaySeconds time.Duration
+// proxyTraffic bi-directionally copies data between two connections.
+func proxyTraffic(client, target net.Conn) {
+	log.Printf("Starting traffic proxy between %s and %s", client.RemoteAddr(), target.RemoteAddr())
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		defer target.Close() // Ensure the other connection is closed on exit
+		io.Copy(target, client)
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer client.Close() // Ensure the other connection is closed on exit
+		io.Copy(client, target)
+	}()
+
+	wg.Wait()
+	log.Printf("Proxy connection between %s and %s closed.", client.RemoteAddr(), target.RemoteAddr())
 }
 
-// loadConfig loads configuration from environment variables with defaults.
-func loadConfig() (*Config, error) {
-	// Helper to get an environment variable or return a default value.
-	getEnv := func(key, fallback string) string {
-		if value, exists := os.LookupEnv(key); exists {
-			return value
-		}
-		return fallback
+// closeWrite shuts down the sending side of conn, if it can, so the peer reads
+// EOF but can still reply.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
 	}
+}
 
-	// Helper to get an integer environment variable.
-	getEnvAsInt := func(key string, fallback int) (int, error) {
-		strValue := getEnv(key, "")
-		if strValue == "" {
-			return fallback, nil
-		}
-		val, err := strconv.Atoi(strValue)
-		if err != nil {
-			return 0, fmt.Errorf("invalid value for %s: %v", key, err)
-		}
-		return val, nil
-	}
+// wakeTarget performs the wakeup and waits until the route's target passes its readiness probe.
+// Attempts are spaced by the configured backoff and the whole wake is bounded by WakeTimeout.
+// The wait stops early when ctx is cancelled, e.g. on shutdown.
+func wakeTarget(ctx context.Context, r *route, cfg *Config) error {
+	deadline := time.Now().Add(cfg.WakeTimeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	m := r.machine
 
-	getEnvAsBool := func(key string, fallback bool) bool {
-		strValue := getEnv(key, "")
-		if strValue == "" {
-			return fallback
-		}
-		val, err := strconv.ParseBool(strValue)
-		if err != nil {
-			return fallback
-		}
-		return val
+	// 1. Perform Wakeup
+	result, err := m.wake(ctx)
+	if err != nil {
+		return fmt.Errorf("error performing wakeup: %w", err)
 	}
-
-	targetHost := os.Getenv("TARGET_HOST")
-	if targetHost == "" {
-		return nil, fmt.Errorf("TARGET_HOST environment variable is required")
+	log.Printf("Wakeup of machine %s: %v", m.name, result.Status)
+	if result.Status == provider.WakeStarted {
+		go m.resendWake(ctx)
 	}
 
-	wakeupMethod := strings.ToLower(getEnv("WAKEUP_METHOD", "wol"))
-	targetMAC := os.Getenv("TARGET_MAC")
+	// The wake may have discovered the target's host.
+	targetAddr := r.targetAddr()
 
-	// Validation depends on wakeup method
-	switch wakeupMethod {
-	case "wol":
-		if targetMAC == "" {
-			return nil, fmt.Errorf("TARGET_MAC environment variable is required when WAKEUP_METHOD is 'wol'")
+	// 2. Wait until the target is ready to serve clients
+	log.Printf("Waiting up to %v for target %s to become ready...", time.Until(deadline).Round(time.Second), targetAddr)
+	for attempt := 1; ; attempt++ {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("target server was not ready after %d attempts within %v: %w", attempt-1, cfg.WakeTimeout, err)
 		}
-	case "proxmox":
-		if getEnv("PROXMOX_API_URL", "") == "" {
-			return nil, fmt.Errorf("PROXMOX_API_URL is required when WAKEUP_METHOD is 'proxmox'")
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for target %s: %w", targetAddr, ctx.Err())
 		}
-		if getEnv("PROXMOX_NODE", "") == "" {
-			return nil, fmt.Errorf("PROXMOX_NODE is required when WAKEUP_METHOD is 'proxmox'")
-		}
-		if getEnv("PROXMOX_VMID", "") == "" {
-			return nil, fmt.Errorf("PROXMOX_VMID is required when WAKEUP_METHOD is 'proxmox'")
+
+		err = r.probe.Check(ctx, targetAddr, min(cfg.DialTimeout, remaining))
+		if err == nil {
+			log.Printf("Target %s passed its readiness probe on attempt %d.", targetAddr, attempt)
+			return nil
 		}
-		if getEnv("PROXMOX_TOKEN", "") == "" {
-			return nil, fmt.Errorf("PROXMOX_TOKEN is required when WAKEUP_METHOD is 'proxmox'")
+
+		delay := min(cfg.Backoff.Delay(attempt), time.Until(deadline))
+		log.Printf("Attempt %d failed readiness probe: %v. Retrying in %v...", attempt, err, delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
 		}
 	}
+}
 
-	proxyPort, err := getEnvAsInt("PROXY_PORT", 2222)
-	if err != nil {
-		return nil, err
-	}
+// dialTarget opens a TCP connection to the target, giving up after timeout or when ctx is cancelled.
+func dialTarget(ctx context.Context, targetAddr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return dialer.DialContext(ctx, "tcp", targetAddr)
+}
 
-	targetPort, err := getEnvAsInt("TARGET_PORT", 22)
-	if err != nil {
-		return nil, err
+// connectTarget connects to the route's target. A target that was recently seen
+// up, or that passes its readiness probe straight away, is connected to without
+// a wakeup; otherwise the machine is woken first. A machine being put to sleep
+// is only looked at once it is asleep.
+func connectTarget(ctx context.Context, r *route, cfg *Config) (net.Conn, error) {
+	m := r.machine
+	m.waitAsleep()
+	targetAddr := r.targetAddr()
+
+	// 1. Fast path: skip the wakeup if the target is already reachable
+	if m.knownUp(targetAddr) {
+		targetConn, err := dialTarget(ctx, targetAddr, cfg.DialTimeout)
+		if err == nil {
+			m.markUp(targetAddr)
+			return targetConn, nil
+		}
+		log.Printf("Target %s was known to be up but could not be reached: %v", targetAddr, err)
+		m.markDown(targetAddr)
+	} else if cfg.FastTimeout > 0 && m.currentHost() != "" && r.probe.Check(ctx, targetAddr, cfg.FastTimeout) == nil {
+		log.Printf("Target %s is already up. Skipping wakeup.", targetAddr)
+		targetConn, err := dialTarget(ctx, targetAddr, cfg.DialTimeout)
+		if err == nil {
+			m.markUp(targetAddr)
+			return targetConn, nil
+		}
 	}
 
-	connectionRetries, err := getEnvAsInt("CONNECTION_RETRIES", 15)
-	if err != nil {
+	// 2. Wake the target, or join the wake already in progress
+	if err := m.waitReady(ctx, r, cfg); err != nil {
 		return nil, err
 	}
 
-	retryDelay, err := getEnvAsInt("RETRY_DELAY_SECONDS", 5)
+	// 3. Connect to the now ready target, whose host the wake may have discovered
+	targetAddr = r.targetAddr()
+	targetConn, err := dialTarget(ctx, targetAddr, cfg.DialTimeout)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not connect to target %s: %w", targetAddr, err)
 	}
-
-	return &Config{
-		ProxyHost:         getEnv("PROXY_HOST", "0.0.0.0"),
-		ProxyPort:         proxyPort,
-		TargetHost:        targetHost,
-		TargetPort:        targetPort,
-		TargetMAC:         targetMAC,
-		TargetBroadcastIP: getEnv("TARGET_BROADCAST_IP", "255.255.255.255"),
-		ProxmoxAPIURL:     getEnv("PROXMOX_API_URL", ""),
-		ProxmoxNode:       getEnv("PROXMOX_NODE", ""),
-		ProxmoxVMID:       getEnv("PROXMOX_VMID", ""),
-		ProxmoxToken:      getEnv("PROXMOX_TOKEN", ""),
-		ProxmoxType:       getEnv("PROXMOX_TYPE", "qemu"), // default to qemu (VM), can be lxc
-		ProxmoxInsecure:   getEnvAsBool("PROXMOX_INSECURE", false),
-		WakeupMethod:      wakeupMethod,
-		ConnectionRetries: connectionRetries,
-		RetryDelaySeconds: time.Duration(retryDelay) * time.Second,
-	}, nil
-}
-
-// proxyTraffic bi-directionally copies data between two connections.
-func proxyTraffic(client, target net.Conn) {
-	log.Printf("Starting traffic proxy between %s and %s", client.RemoteAddr(), target.RemoteAddr())
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		defer target.Close() // Ensure the other connection is closed on exit
-		io.Copy(target, client)
-	}()
-
-	go func() {
-		defer wg.Done()
-		defer client.Close() // Ensure the other connection is closed on exit
-		io.Copy(client, target)
-	}()
-
-	wg.Wait()
-	log.Printf("Proxy connection between %s and %s closed.", client.RemoteAddr(), target.RemoteAddr())
+	m.markUp(targetAddr)
+	return targetConn, nil
 }
 
 // handleClient manages an incoming client connection.
-func handleClient(clientConn net.Conn, cfg *Config, wakeupProvider provider.WakeupProvider) {
+// Concurrent clients for the same target share a single wake and readiness wait.
+// The connection counts as an active session on the machine until it closes.
+func handleClient(ctx context.Context, clientConn net.Conn, cfg *Config, r *route) {
 	defer clientConn.Close()
 	log.Printf("Accepted connection from %s", clientConn.RemoteAddr())
 
-	// 1. Perform Wakeup
-	if err := wakeupProvider.Wake(); err != nil {
-		log.Printf("Error performing wakeup: %v", err)
-		return
-	}
+	r.machine.idle.Acquire()
+	defer r.machine.idle.Release()
 
-	// 2. Wait and attempt to connect to the target SSH server
-	var targetConn net.Conn
-	var err error
-	targetAddr := net.JoinHostPort(cfg.TargetHost, strconv.Itoa(cfg.TargetPort))
+	// Stop waiting for the target as soon as the client hangs up.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher := watchClient(clientConn, cancel)
 
-	log.Printf("Attempting to connect to target %s...", targetAddr)
-	for i := 0; i < cfg.ConnectionRetries; i++ {
-		targetConn, err = net.DialTimeout("tcp", targetAddr, cfg.RetryDelaySeconds)
-		if err == nil {
-			log.Printf("Successfully connected to target %s on attempt %d.", targetAddr, i+1)
-			break
+	targetConn, err := connectTarget(ctx, r, cfg)
+	early, hungUp, eof := watcher.Stop()
+	if hungUp {
+		log.Printf("Client %s hung up while waiting for the target.", clientConn.RemoteAddr())
+		if targetConn != nil {
+			targetConn.Close()
 		}
-		log.Printf("Attempt %d/%d failed to connect to target: %v. Retrying in %v...", i+1, cfg.ConnectionRetries, err, cfg.RetryDelaySeconds)
-		time.Sleep(cfg.RetryDelaySeconds)
+		return
 	}
-
-	if targetConn == nil {
-		log.Printf("Could not connect to target server after %d attempts. Closing client connection.", cfg.ConnectionRetries)
+	if err != nil {
+		log.Printf("%v. Closing client connection %s.", err, clientConn.RemoteAddr())
 		return
 	}
 	defer targetConn.Close()
 
-	// 3. Start proxying traffic
-	proxyTraffic(clientConn, targetConn)
+	// Forward anything the client sent while it was waiting.
+	if len(early) > 0 {
+		if _, err := targetConn.Write(early); err != nil {
+			log.Printf("Failed to forward data from client %s to target: %v", clientConn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	if eof {
+		// The client is done sending, but may still wait for the reply
+		closeWrite(targetConn)
+		io.Copy(clientConn, targetConn)
+	} else {
+		proxyTraffic(clientConn, targetConn)
+	}
+
+	// The target served the session until now, so it is still up.
+	r.machine.markUp(r.targetAddr())
+}
+
+// serveRoute accepts connections for a route until the listener is closed.
+// Every accepted connection is registered with sessions so it can be drained on shutdown.
+func serveRoute(ctx context.Context, listener net.Listener, cfg *Config, r *route, sessions *sessionTracker) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to accept connection on %s: %v", listener.Addr(), err)
+			continue
+		}
+		// Handle each client connection in a new goroutine
+		sessions.Add(conn)
+		go func() {
+			defer sessions.Done(conn)
+			handleClient(ctx, conn, cfg, r)
+		}()
+	}
 }
 
 func main() {
-	cfg, err := loadConfig()
-	if err != nil {
-		log.Fatalf("Configuration error: %v", err)
+	configPath := flag.String("config", getEnv("MOP_CONFIG", ""), "path to a YAML config file (env: MOP_CONFIG)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s relay\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	listenAddr := net.JoinHostPort(cfg.ProxyHost, strconv.Itoa(cfg.ProxyPort))
-	listener, err := net.Listen("tcp", listenAddr)
+	// "mop relay" sends magic packets for other mop instances instead
+	if flag.Arg(0) == "relay" {
+		runRelay()
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to start listener on %s: %v", listenAddr, err)
+		log.Fatalf("Configuration error: %v", err)
 	}
-	defer listener.Close()
-	log.Printf("mop server listening on %s", listenAddr)
 
-	var wakeupProvider provider.WakeupProvider
-	switch cfg.WakeupMethod {
-	case "wol":
-		wakeupProvider = &provider.WOLProvider{
-			TargetMAC:         cfg.TargetMAC,
-			TargetBroadcastIP: cfg.TargetBroadcastIP,
+	machines := make(map[string]*machine)
+	for _, mc := range cfg.Machines {
+		m, err := newMachine(mc, cfg)
+		if err != nil {
+			log.Fatalf("Configuration error: %v", err)
 		}
-	case "proxmox":
-		wakeupProvider = &provider.ProxmoxProvider{
-			APIURL:   cfg.ProxmoxAPIURL,
-			Node:     cfg.ProxmoxNode,
-			VMID:     cfg.ProxmoxVMID,
-			Token:    cfg.ProxmoxToken,
-			Type:     cfg.ProxmoxType,
-			Insecure: cfg.ProxmoxInsecure,
+		machines[mc.Name] = m
+		host := m.currentHost()
+		if host == "" {
+			host = "host discovered on wake"
 		}
-	case "noop":
-		wakeupProvider = &provider.NoopProvider{}
-	default:
-		log.Fatalf("Unknown wakeup method: %s", cfg.WakeupMethod)
+		log.Printf("Using wakeup provider %T for machine %s (%s)", m.provider, m.name, host)
+	}
+	if cfg.IdleTimeout > 0 {
+		log.Printf("Idle shutdown enabled after %v without connections", cfg.IdleTimeout)
 	}
-	log.Printf("Using wakeup provider: %T", wakeupProvider)
 
-	for {
-		conn, err := listener.Accept()
+	// Cancelled on SIGINT/SIGTERM, which stops pending wakes and readiness waits.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	sessions := newSessionTracker()
+	var listeners []net.Listener
+	for _, rc := range cfg.Routes {
+		readiness, err := newProbe(rc.Probe)
 		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
-			continue
+			log.Fatalf("Configuration error: %v", err)
 		}
-		// Handle each client connection in a new goroutine
-		go handleClient(conn, cfg, wakeupProvider)
+		r := &route{RouteConfig: rc, machine: machines[rc.Machine], probe: readiness}
+
+		listenAddr := net.JoinHostPort(r.ProxyHost, strconv.Itoa(r.ProxyPort))
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			log.Fatalf("Failed to start listener on %s: %v", listenAddr, err)
+		}
+		listeners = append(listeners, listener)
+		log.Printf("mop server listening on %s, proxying to %s port %d (%s readiness probe)", listenAddr, r.machine.name, r.TargetPort, r.Probe.Type)
+
+		go serveRoute(ctx, listener, cfg, r, sessions)
+	}
+
+	<-ctx.Done()
+	// Restore default signal handling, so a second signal terminates immediately.
+	stop()
+
+	log.Printf("Shutting down: no longer accepting connections, draining %d sessions for up to %v", sessions.Count(), cfg.ShutdownTimeout)
+	for _, listener := range listeners {
+		listener.Close()
+	}
+
+	drained := sessions.Wait(cfg.ShutdownTimeout)
+	if !drained {
+		log.Printf("Shutdown timeout exceeded, closing %d remaining sessions", sessions.Count())
+		sessions.CloseAll()
+	}
+	for _, m := range machines {
+		m.close()
+	}
+	if !drained {
+		os.Exit(1)
 	}
+	log.Println("All sessions drained. Exiting.")
 }
@@ -0,0 +1,47 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// maxExpectBytes caps how much of the response ExpectProbe reads while looking for a match.
+const maxExpectBytes = 64 * 1024
+
+// ExpectProbe is a Probe that sends Send, if set, and then reads until the
+// response matches Expect.
+type ExpectProbe struct {
+	Send   []byte
+	Expect *regexp.Regexp
+}
+
+func (p *ExpectProbe) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	conn, closeConn, err := dial(ctx, addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if len(p.Send) > 0 {
+		if _, err := conn.Write(p.Send); err != nil {
+			return fmt.Errorf("failed to send probe: %w", err)
+		}
+	}
+
+	var response []byte
+	buf := make([]byte, 4096)
+	for len(response) < maxExpectBytes {
+		n, err := conn.Read(buf)
+		response = append(response, buf[:n]...)
+		if p.Expect.Match(response) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("response did not match %q: %w", p.Expect, err)
+		}
+	}
+
+	return fmt.Errorf("response did not match %q within %d bytes", p.Expect, maxExpectBytes)
+}
@@ -0,0 +1,60 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExpectProbe(t *testing.T) {
+	// A tiny line based server that answers PING with PONG.
+	addr := serve(t, func(conn net.Conn) {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "PING\r\n" {
+			io.WriteString(conn, "+PONG\r\n")
+		} else {
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+	})
+
+	tests := []struct {
+		name        string
+		send        string
+		expect      string
+		expectError bool
+	}{
+		{name: "Matching Response", send: "PING\r\n", expect: `^\+PONG`, expectError: false},
+		{name: "Mismatched Response", send: "HELLO\r\n", expect: `^\+PONG`, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ExpectProbe{Send: []byte(tt.send), Expect: regexp.MustCompile(tt.expect)}
+			err := p.Check(context.Background(), addr, time.Second)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestExpectProbeWithoutSend(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		io.WriteString(conn, "220 mail.example.com ESMTP ready\r\n")
+	})
+
+	p := &ExpectProbe{Expect: regexp.MustCompile(`^220 `)}
+	if err := p.Check(context.Background(), addr, time.Second); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
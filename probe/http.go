@@ -0,0 +1,63 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPProbe is a Probe that sends a GET request and expects a specific status code.
+type HTTPProbe struct {
+	Path           string
+	ExpectedStatus int
+	TLS            bool
+	Insecure       bool
+}
+
+func (p *HTTPProbe) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	scheme := "http"
+	if p.TLS {
+		scheme = "https"
+	}
+	path := p.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	expectedStatus := p.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: p.Insecure},
+			DisableKeepAlives: true,
+		},
+		// Report redirects as the status code instead of following them.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", scheme, addr, path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("expected HTTP status %d, got %d", expectedStatus, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/starting":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectError    bool
+	}{
+		{name: "Healthy", path: "/health", expectError: false},
+		{name: "Path Without Slash", path: "health", expectError: false},
+		{name: "Unhealthy", path: "/starting", expectError: true},
+		{name: "Expected Non 200 Status", path: "/starting", expectedStatus: http.StatusServiceUnavailable, expectError: false},
+		{name: "Redirect Not Followed", path: "/redirect", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			p := &HTTPProbe{Path: tt.path, ExpectedStatus: tt.expectedStatus}
+			err := p.Check(context.Background(), strings.TrimPrefix(server.URL, "http://"), time.Second)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPProbeTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	p := &HTTPProbe{Path: "/", TLS: true, Insecure: true}
+	if err := p.Check(context.Background(), strings.TrimPrefix(server.URL, "https://"), time.Second); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
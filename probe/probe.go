@@ -0,0 +1,37 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Probe checks whether the service at an address is ready to serve clients.
+// A nil error means the client can be forwarded. A check gives up after
+// timeout or when ctx is cancelled, whichever comes first.
+type Probe interface {
+	Check(ctx context.Context, addr string, timeout time.Duration) error
+}
+
+// dial opens a TCP connection for a probe. The connection's deadline is set to
+// timeout, and it is interrupted as soon as ctx is cancelled.
+func dial(ctx context.Context, addr string, timeout time.Duration) (net.Conn, func(), error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+
+	return conn, func() {
+		stop()
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,38 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxPreBannerLines is how many lines a server may send before its SSH
+// identification string (RFC 4253, section 4.2) before the probe gives up.
+const maxPreBannerLines = 16
+
+// SSHProbe is a Probe that waits for the server's SSH identification string,
+// e.g. "SSH-2.0-OpenSSH_9.6", rather than just an open port.
+type SSHProbe struct{}
+
+func (p *SSHProbe) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	conn, closeConn, err := dial(ctx, addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	reader := bufio.NewReader(conn)
+	for range maxPreBannerLines {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-2.0-") || strings.HasPrefix(line, "SSH-1.99-") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("no SSH banner received: %w", err)
+		}
+	}
+
+	return fmt.Errorf("no SSH banner within the first %d lines", maxPreBannerLines)
+}
@@ -0,0 +1,65 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSSHProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		expectError bool
+	}{
+		{name: "SSH 2.0 Banner", response: "SSH-2.0-OpenSSH_9.6\r\n", expectError: false},
+		{name: "Banner After Preamble", response: "Welcome\r\nSSH-2.0-dropbear\r\n", expectError: false},
+		{name: "Not SSH", response: "HTTP/1.1 400 Bad Request\r\n\r\n", expectError: true},
+		{name: "Closed Without Banner", response: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serve(t, func(conn net.Conn) {
+				io.WriteString(conn, tt.response)
+			})
+
+			err := (&SSHProbe{}).Check(context.Background(), addr, time.Second)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSSHProbeTimesOutOnSilentServer(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		time.Sleep(500 * time.Millisecond)
+	})
+
+	if err := (&SSHProbe{}).Check(context.Background(), addr, 50*time.Millisecond); err == nil {
+		t.Error("Expected timeout error, got nil")
+	}
+}
+
+func TestSSHProbeStopsWhenCancelled(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := (&SSHProbe{}).Check(ctx, addr, 10*time.Second); err == nil {
+		t.Error("Expected error for a cancelled probe, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected probe to stop when the context expired, took %v", elapsed)
+	}
+}
@@ -0,0 +1,19 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// TCPProbe is a Probe that succeeds as soon as the address accepts a TCP connection.
+type TCPProbe struct{}
+
+func (p *TCPProbe) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
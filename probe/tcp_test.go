@@ -0,0 +1,21 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPProbe(t *testing.T) {
+	p := &TCPProbe{}
+
+	addr := serve(t, func(conn net.Conn) {})
+	if err := p.Check(context.Background(), addr, time.Second); err != nil {
+		t.Errorf("Expected open port to pass, got %v", err)
+	}
+
+	if err := p.Check(context.Background(), closedAddr(t), time.Second); err == nil {
+		t.Error("Expected closed port to fail, got nil")
+	}
+}
@@ -0,0 +1,39 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TLSProbe is a Probe that succeeds once a TLS handshake with the address completes.
+// ServerName defaults to the host of the address.
+type TLSProbe struct {
+	ServerName string
+	Insecure   bool
+}
+
+func (p *TLSProbe) Check(ctx context.Context, addr string, timeout time.Duration) error {
+	serverName := p.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		serverName = host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: p.Insecure,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
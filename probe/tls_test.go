@@ -0,0 +1,37 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSProbe(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	if err := (&TLSProbe{Insecure: true}).Check(context.Background(), addr, time.Second); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// The test server's certificate is self-signed, so verification must fail.
+	if err := (&TLSProbe{}).Check(context.Background(), addr, time.Second); err == nil {
+		t.Error("Expected certificate verification error, got nil")
+	}
+}
+
+func TestTLSProbePlainTCP(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		io.WriteString(conn, "SSH-2.0-OpenSSH_9.6\r\n")
+	})
+
+	if err := (&TLSProbe{Insecure: true}).Check(context.Background(), addr, time.Second); err == nil {
+		t.Error("Expected handshake error, got nil")
+	}
+}
@@ -1,11 +1,20 @@
 package provider
 
-import "log"
+import (
+	"context"
+	"log"
+)
 
 // NoopProvider is a WakeupProvider that does nothing.
+// The target is assumed to be running already.
 type NoopProvider struct{}
 
-func (n *NoopProvider) Wake() error {
+func (n *NoopProvider) Wake(ctx context.Context) (WakeResult, error) {
 	log.Println("Noop wakeup: doing nothing")
+	return WakeResult{Status: WakeAlreadyRunning}, nil
+}
+
+func (n *NoopProvider) Sleep(ctx context.Context) error {
+	log.Println("Noop sleep: doing nothing")
 	return nil
 }
@@ -1,12 +1,20 @@
 package provider
 
 import (
+	"context"
 	"testing"
 )
 
 func TestNoopProvider(t *testing.T) {
 	p := &NoopProvider{}
-	if err := p.Wake(); err != nil {
+	result, err := p.Wake(context.Background())
+	if err != nil {
 		t.Errorf("NoopProvider.Wake() returned error: %v", err)
 	}
+	if result.Status != WakeAlreadyRunning {
+		t.Errorf("Expected status %v, got %v", WakeAlreadyRunning, result.Status)
+	}
+	if err := p.Sleep(context.Background()); err != nil {
+		t.Errorf("NoopProvider.Sleep() returned error: %v", err)
+	}
 }
@@ -1,6 +1,44 @@
 package provider
 
-// WakeupProvider defines an interface for performing a wake-up action.
+import "context"
+
+// WakeupProvider defines an interface for performing a wake-up action
+// and the matching action that puts the target back to sleep.
+// Both give up when ctx is cancelled.
 type WakeupProvider interface {
-	Wake() error
+	Wake(ctx context.Context) (WakeResult, error)
+	Sleep(ctx context.Context) error
+}
+
+// WakeStatus describes the outcome of a wake-up action.
+type WakeStatus int
+
+const (
+	// WakeFailed means the wake-up action failed. It is returned with an error.
+	WakeFailed WakeStatus = iota
+	// WakeAlreadyRunning means the target was already running and nothing was done.
+	WakeAlreadyRunning
+	// WakeStarted means the target was asked to start and may still be booting.
+	WakeStarted
+)
+
+func (s WakeStatus) String() string {
+	switch s {
+	case WakeFailed:
+		return "failed"
+	case WakeAlreadyRunning:
+		return "already running"
+	case WakeStarted:
+		return "started"
+	default:
+		return "unknown"
+	}
+}
+
+// WakeResult is the structured result of a wake-up action.
+type WakeResult struct {
+	Status WakeStatus
+	// Address is the target's host as discovered by the provider, e.g. an IP
+	// assigned by DHCP. It is empty if the provider does not know it.
+	Address string
 }
@@ -1,34 +1,124 @@
 package provider
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// defaultProxmoxTimeout bounds each Proxmox API request when no Timeout is configured.
+	defaultProxmoxTimeout = 10 * time.Second
+	// proxmoxIdleConnTimeout is how long an unused connection to the API is kept open.
+	proxmoxIdleConnTimeout = 90 * time.Second
+	// proxmoxMaxIdleConns is how many unused connections to the API are kept open.
+	proxmoxMaxIdleConns = 4
+)
+
+// Sleep actions of the ProxmoxProvider.
+const (
+	ProxmoxSleepShutdown  = "shutdown"  // graceful shutdown via status/shutdown
+	ProxmoxSleepSuspend   = "suspend"   // pause the VM, keeping its memory (qemu only)
+	ProxmoxSleepHibernate = "hibernate" // suspend the VM to disk (qemu only)
+)
+
 // ProxmoxProvider is a WakeupProvider that calls Proxmox API to start a VM/CT.
 type ProxmoxProvider struct {
-	APIURL   string
-	Node     string
-	VMID     string
-	Token    string
-	Type     string
-	Insecure bool
+	APIURL string
+	// Node is the node the guest is expected on. If it is empty, or the guest
+	// is not found there, the node is looked up in the cluster resources.
+	Node string
+	// VMID, Name and Tag select the guest. If Name or Tag is set, the guest
+	// is looked up in the cluster resources and must match all of them.
+	VMID string
+	Name string
+	Tag  string
+	// Token is an API token, "USER@REALM!TOKENID=UUID". Without a token,
+	// the provider logs in with Username, Password and, for accounts with
+	// two-factor authentication, a code generated from TOTPSecret.
+	Token      string
+	Username   string
+	Password   string
+	TOTPSecret string
+	Type       string
+	Timeout    time.Duration // per API request, defaults to 10s
+
+	// Insecure skips verifying the API's certificate. Prefer CAFile, a PEM
+	// file of CAs to verify it against instead of the system roots, or
+	// Fingerprints, which pins it by its SHA-256 fingerprint and also
+	// accepts self-signed certificates.
+	Insecure     bool
+	CAFile       string
+	Fingerprints []string
+
+	// TaskTimeout bounds how long to wait for a task such as a start to
+	// finish, and for a lock such as a backup to be released. Defaults to 2m.
+	TaskTimeout time.Duration
+
+	// SleepAction is how the guest is put to sleep: ProxmoxSleepShutdown
+	// (the default), ProxmoxSleepSuspend or ProxmoxSleepHibernate.
+	SleepAction string
+
+	// AgentTimeout, if set, makes Wake wait up to this long for the QEMU
+	// guest agent to answer a ping before reporting the guest as woken.
+	AgentTimeout time.Duration
+
+	// DiscoverAddress makes Wake return the guest's address as reported by
+	// the QEMU guest agent or the container's interfaces. AddressCIDRs and
+	// AddressInterfaces restrict which addresses are considered.
+	DiscoverAddress   bool
+	AddressCIDRs      []netip.Prefix
+	AddressInterfaces []string
+
+	// DependsOn lists guests that are woken, in order, before this one. They
+	// are not put to sleep with it, since other guests may depend on them too.
+	DependsOn []ProxmoxDependency
+
+	parent   *ProxmoxProvider // owns the API connection of a dependency
+	depsOnce sync.Once
+	deps     []*ProxmoxProvider // set by dependencies
+
+	mu       sync.Mutex
+	resolved proxmoxGuest // set by resolveGuest
+
+	authMu sync.Mutex
+	auth   proxmoxTicket // set by ticket
+
+	clientOnce sync.Once
+	client     *http.Client // set by httpClient
+	clientErr  error
+}
+
+// ProxmoxGuestStatus is the state of a VM/CT as reported by status/current.
+type ProxmoxGuestStatus struct {
+	Status string `json:"status"` // "running" or "stopped"
+	// QMPStatus is the state reported by QEMU, e.g. "running", "paused" or
+	// "suspended". It is empty for containers.
+	QMPStatus string `json:"qmpstatus"`
+	// Lock is set while an operation such as a backup or migration holds the
+	// guest, and is "suspended" for a VM hibernated to disk.
+	Lock string `json:"lock"`
 }
 
 type ProxmoxStatusResponse struct {
-	Data struct {
-		Status string `json:"status"`
-	} `json:"data"`
+	Data ProxmoxGuestStatus `json:"data"`
+}
+
+// ProxmoxTaskResponse is the response of API calls that start a task, such as
+// status/start. Data holds the task's UPID.
+type ProxmoxTaskResponse struct {
+	Data string `json:"data"`
 }
 
-func (p *ProxmoxProvider) Wake() error {
-	// Construct URL base
+// baseURL returns the normalised Proxmox API base URL.
+func (p *ProxmoxProvider) baseURL() string {
 	baseURL := strings.TrimRight(p.APIURL, "/")
 
 	// Auto-upgrade http to https
@@ -36,94 +126,265 @@ func (p *ProxmoxProvider) Wake() error {
 		log.Printf("Warning: Proxmox API URL uses http. Upgrading to https to avoid redirect issues.")
 		baseURL = strings.Replace(baseURL, "http://", "https://", 1)
 	}
+	return baseURL
+}
+
+// guestPath returns the API path of an endpoint of the configured VM/CT, e.g. "status/current".
+func (p *ProxmoxProvider) guestPath(endpoint string) string {
+	g := p.guest()
+	return fmt.Sprintf("/nodes/%s/%s/%s/%s", g.node, g.typ, g.vmid, endpoint)
+}
+
+// makeRequest calls an endpoint of the configured VM/CT, e.g. "status/current".
+func (p *ProxmoxProvider) makeRequest(ctx context.Context, method, endpoint string, params url.Values) (*http.Response, error) {
+	return p.apiRequest(ctx, method, p.guestPath(endpoint), params)
+}
+
+// apiRequest calls an API path below the base URL, e.g. "/nodes/pve1/tasks/...".
+// Any params are sent form-encoded in the request body.
+// The request is bounded by both ctx and the provider's Timeout.
+func (p *ProxmoxProvider) apiRequest(ctx context.Context, method, path string, params url.Values) (*http.Response, error) {
+	if p.parent != nil {
+		return p.parent.apiRequest(ctx, method, path, params)
+	}
+
+	resp, err := p.send(ctx, method, path, params, p.authorize)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || p.Token != "" {
+		return resp, err
+	}
+
+	// The ticket was rejected before it expired, e.g. because the cluster's
+	// authentication key was rotated. Log in again once.
+	resp.Body.Close()
+	log.Printf("Proxmox rejected the login ticket. Logging in again.")
+	p.invalidateTicket()
+	return p.send(ctx, method, path, params, p.authorize)
+}
+
+// send performs a single request to an API path, authenticated by authorize
+// unless it is nil.
+func (p *ProxmoxProvider) send(ctx context.Context, method, path string, params url.Values, authorize func(context.Context, *http.Request) error) (*http.Response, error) {
+	url := p.baseURL() + path
+	log.Printf("Proxmox Request: %s %s", method, url)
+
+	var body io.Reader
+	if len(params) > 0 {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
 
-	resourceType := p.Type
-	if resourceType == "" {
-		resourceType = "qemu"
+	if authorize != nil {
+		if err := authorize(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
-	// Helper to make requests
-	makeRequest := func(method, endpoint string) (*http.Response, error) {
-		url := fmt.Sprintf("%s/nodes/%s/%s/%s/%s", baseURL, p.Node, resourceType, p.VMID, endpoint)
-		log.Printf("Proxmox Request: %s %s", method, url)
+	client, err := p.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
 
-		req, err := http.NewRequest(method, url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+// status returns the current state of the VM/CT. If the guest's node or VMID
+// is not known, or the guest cannot be found there, it is looked up first.
+func (p *ProxmoxProvider) status(ctx context.Context) (ProxmoxGuestStatus, error) {
+	if g := p.guest(); g.node == "" || g.vmid == "" {
+		if _, err := p.resolveGuest(ctx); err != nil {
+			return ProxmoxGuestStatus{}, err
 		}
+	}
+
+	st, err := p.currentStatus(ctx)
+	if err == nil || ctx.Err() != nil {
+		return st, err
+	}
+
+	// The guest may have been migrated to another node or rebuilt.
+	log.Printf("Proxmox status check failed, looking up the guest in the cluster: %v", err)
+	moved, resolveErr := p.resolveGuest(ctx)
+	if resolveErr != nil {
+		log.Printf("Proxmox node lookup failed: %v", resolveErr)
+		return st, err
+	}
+	if !moved {
+		return st, err
+	}
+	return p.currentStatus(ctx)
+}
+
+// currentStatus fetches status/current from the guest's node.
+func (p *ProxmoxProvider) currentStatus(ctx context.Context) (ProxmoxGuestStatus, error) {
+	var statusResp ProxmoxStatusResponse
+	if err := p.getJSON(ctx, p.guestPath("status/current"), &statusResp); err != nil {
+		return ProxmoxGuestStatus{}, fmt.Errorf("failed to check proxmox status: %w", err)
+	}
 
-		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s", p.Token))
+	st := statusResp.Data
+	log.Printf("Current Proxmox Status: %s (qmpstatus: %s, lock: %s)", st.Status, orNone(st.QMPStatus), orNone(st.Lock))
+	return st, nil
+}
+
+// waitUnlocked returns the status of the VM/CT once no operation such as a
+// backup or migration holds its lock. A VM hibernated to disk keeps the
+// "suspended" lock until it is started, so that lock is not waited for.
+func (p *ProxmoxProvider) waitUnlocked(ctx context.Context) (ProxmoxGuestStatus, error) {
+	st, err := p.status(ctx)
+	if err != nil || !st.locked() {
+		return st, err
+	}
+
+	timeout := p.TaskTimeout
+	if timeout <= 0 {
+		timeout = defaultProxmoxTaskTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: p.Insecure},
+	log.Printf("Proxmox guest is locked (%s). Waiting for the lock to be released.", st.Lock)
+	for st.locked() {
+		select {
+		case <-ctx.Done():
+			return st, fmt.Errorf("guest is still locked (%s): %w", st.Lock, ctx.Err())
+		case <-time.After(proxmoxTaskPollInterval):
 		}
 
-		client := &http.Client{
-			Transport: tr,
-			Timeout:   10 * time.Second,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= 10 {
-					return fmt.Errorf("stopped after 10 redirects")
-				}
-				if len(via) > 0 {
-					lastReq := via[len(via)-1]
-					if auth := lastReq.Header.Get("Authorization"); auth != "" {
-						req.Header.Set("Authorization", auth)
-					}
-				}
-				return nil
-			},
+		if st, err = p.status(ctx); err != nil {
+			return st, err
 		}
+	}
+	return st, nil
+}
+
+// locked reports whether an operation holds the guest's lock.
+func (st ProxmoxGuestStatus) locked() bool {
+	return st.Lock != "" && st.Lock != "suspended"
+}
+
+// paused reports whether a running VM is paused or suspended in memory.
+func (st ProxmoxGuestStatus) paused() bool {
+	return st.Status == "running" && (st.QMPStatus == "paused" || st.QMPStatus == "suspended")
+}
 
-		return client.Do(req)
+func orNone(s string) string {
+	if s == "" {
+		return "none"
 	}
+	return s
+}
 
-	// 1. Check Status
-	resp, err := makeRequest("GET", "status/current")
-	if err != nil {
-		return fmt.Errorf("failed to check proxmox status: %w", err)
+func (p *ProxmoxProvider) Wake(ctx context.Context) (WakeResult, error) {
+	// 0. Bring up the guests this one depends on
+	if err := p.wakeDependencies(ctx); err != nil {
+		return WakeResult{Status: WakeFailed}, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	result, err := p.wake(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read status body: %w", err)
+		return result, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("proxmox status check returned error %d: %s", resp.StatusCode, string(body))
+	// 3. Wait for the guest's OS to boot
+	if p.AgentTimeout > 0 && p.guest().typ == "qemu" {
+		if err := p.waitAgent(ctx); err != nil {
+			return WakeResult{Status: WakeFailed}, err
+		}
 	}
 
-	var statusResp ProxmoxStatusResponse
-	if err := json.Unmarshal(body, &statusResp); err != nil {
-		return fmt.Errorf("failed to parse status json: %w", err)
+	// 4. Find out where the guest can be reached
+	if p.DiscoverAddress {
+		if result.Address, err = p.waitAddress(ctx); err != nil {
+			return WakeResult{Status: WakeFailed}, err
+		}
 	}
+	return result, nil
+}
 
-	log.Printf("Current Proxmox Status: %s", statusResp.Data.Status)
+// wake starts or resumes the guest unless it is already running.
+func (p *ProxmoxProvider) wake(ctx context.Context) (WakeResult, error) {
+	// 1. Check Status, waiting out any backup or migration
+	st, err := p.waitUnlocked(ctx)
+	if err != nil {
+		return WakeResult{Status: WakeFailed}, err
+	}
 
-	if statusResp.Data.Status == "running" {
+	// 2. Pick the action that brings the guest back
+	var endpoint string
+	switch {
+	case st.paused():
+		log.Printf("Container/VM is %s. Resuming it.", st.QMPStatus)
+		endpoint = "status/resume"
+	case st.Status == "running":
 		log.Println("Container/VM is already running. Skipping start command.")
-		return nil
+		return WakeResult{Status: WakeAlreadyRunning}, nil
+	case st.Lock == "suspended":
+		// Starting a hibernated VM restores it from its saved state.
+		log.Println("Container/VM is hibernated. Starting it from its saved state.")
+		endpoint = "status/start"
+	default:
+		endpoint = "status/start"
 	}
 
-	// 2. Start Request if not running
 	// Re-check token format warning (optional, moved from original code)
-	if !strings.Contains(p.Token, "!") || !strings.Contains(p.Token, "=") {
+	if p.Token != "" && (!strings.Contains(p.Token, "!") || !strings.Contains(p.Token, "=")) {
 		log.Printf("Warning: Proxmox Token format looks incorrect. Expected 'USER@REALM!TOKENID=UUID'. Check your configuration.")
 	}
 
-	respStart, err := makeRequest("POST", "status/start")
+	if err := p.runTask(ctx, endpoint, nil); err != nil {
+		return WakeResult{Status: WakeFailed}, fmt.Errorf("proxmox %s failed: %w", endpoint, err)
+	}
+
+	log.Println("Proxmox Wake success")
+	return WakeResult{Status: WakeStarted}, nil
+}
+
+// Sleep puts the VM/CT to sleep according to SleepAction. It defaults to a
+// graceful shutdown via the Proxmox API.
+func (p *ProxmoxProvider) Sleep(ctx context.Context) error {
+	st, err := p.status(ctx)
 	if err != nil {
-		return fmt.Errorf("proxmox start api call failed: %w", err)
+		return err
+	}
+
+	if st.Status != "running" {
+		log.Println("Container/VM is not running. Skipping sleep command.")
+		return nil
+	}
+	if st.locked() {
+		return fmt.Errorf("guest is locked (%s), not putting it to sleep", st.Lock)
 	}
-	defer respStart.Body.Close()
 
-	bodyStart, _ := io.ReadAll(respStart.Body)
+	var (
+		endpoint string
+		params   url.Values
+	)
+	switch p.SleepAction {
+	case "", ProxmoxSleepShutdown:
+		endpoint = "status/shutdown"
+	case ProxmoxSleepSuspend:
+		if st.paused() {
+			log.Printf("Container/VM is already %s. Skipping suspend command.", st.QMPStatus)
+			return nil
+		}
+		endpoint = "status/suspend"
+	case ProxmoxSleepHibernate:
+		endpoint = "status/suspend"
+		params = url.Values{"todisk": {"1"}}
+	default:
+		return fmt.Errorf("unknown proxmox sleep action %q", p.SleepAction)
+	}
 
-	if respStart.StatusCode != http.StatusOK {
-		return fmt.Errorf("proxmox start api returned error %d: %s", respStart.StatusCode, string(bodyStart))
+	if err := p.runTask(ctx, endpoint, params); err != nil {
+		return fmt.Errorf("proxmox %s failed: %w", endpoint, err)
 	}
 
-	log.Printf("Proxmox Wake success: %s", string(bodyStart))
+	log.Println("Proxmox Sleep success")
 	return nil
 }
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// ProxmoxAgentInterfacesResponse is the response of agent/network-get-interfaces for a VM.
+type ProxmoxAgentInterfacesResponse struct {
+	Data struct {
+		Result []struct {
+			Name        string `json:"name"`
+			IPAddresses []struct {
+				IPAddress string `json:"ip-address"`
+				Prefix    int    `json:"prefix"`
+			} `json:"ip-addresses"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// ProxmoxLXCInterfacesResponse is the response of interfaces for a container.
+type ProxmoxLXCInterfacesResponse struct {
+	Data []struct {
+		Name  string `json:"name"`
+		Inet  string `json:"inet"`  // e.g. "192.168.1.51/24"
+		Inet6 string `json:"inet6"` // e.g. "fd00::51/64"
+	} `json:"data"`
+}
+
+// guestInterface is a network interface of a guest with its addresses.
+type guestInterface struct {
+	name  string
+	addrs []netip.Addr
+}
+
+// waitAddress polls the guest's network interfaces until one of them has an
+// address that passes the AddressCIDRs and AddressInterfaces filters. A guest
+// that was just started needs some time before its agent answers and DHCP is
+// done, so errors are retried until ctx is done.
+func (p *ProxmoxProvider) waitAddress(ctx context.Context) (string, error) {
+	for attempt := 1; ; attempt++ {
+		ifaces, err := p.interfaces(ctx)
+		if err == nil {
+			if addr, ok := p.pickAddress(ifaces); ok {
+				log.Printf("Proxmox guest reported address %s", addr)
+				return addr.String(), nil
+			}
+			err = fmt.Errorf("no address matches the configured filters")
+		}
+		if attempt == 1 {
+			log.Printf("Waiting for the Proxmox guest to report its address: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no address found: %w (last error: %v)", ctx.Err(), err)
+		case <-time.After(proxmoxTaskPollInterval):
+		}
+	}
+}
+
+// interfaces fetches the guest's network interfaces, from the QEMU guest agent
+// for VMs or from the container's interfaces for LXC.
+func (p *ProxmoxProvider) interfaces(ctx context.Context) ([]guestInterface, error) {
+	if p.guest().typ == "lxc" {
+		var resp ProxmoxLXCInterfacesResponse
+		if err := p.getJSON(ctx, p.guestPath("interfaces"), &resp); err != nil {
+			return nil, fmt.Errorf("failed to list container interfaces: %w", err)
+		}
+
+		ifaces := make([]guestInterface, 0, len(resp.Data))
+		for _, i := range resp.Data {
+			iface := guestInterface{name: i.Name}
+			for _, inet := range []string{i.Inet, i.Inet6} {
+				if prefix, err := netip.ParsePrefix(inet); err == nil {
+					iface.addrs = append(iface.addrs, prefix.Addr())
+				}
+			}
+			ifaces = append(ifaces, iface)
+		}
+		return ifaces, nil
+	}
+
+	var resp ProxmoxAgentInterfacesResponse
+	if err := p.getJSON(ctx, p.guestPath("agent/network-get-interfaces"), &resp); err != nil {
+		return nil, fmt.Errorf("failed to query the guest agent: %w", err)
+	}
+
+	ifaces := make([]guestInterface, 0, len(resp.Data.Result))
+	for _, i := range resp.Data.Result {
+		iface := guestInterface{name: i.Name}
+		for _, a := range i.IPAddresses {
+			if addr, err := netip.ParseAddr(a.IPAddress); err == nil {
+				iface.addrs = append(iface.addrs, addr)
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+// pickAddress returns the first usable address of the interfaces, preferring
+// IPv4. Loopback and link-local addresses are never used.
+func (p *ProxmoxProvider) pickAddress(ifaces []guestInterface) (netip.Addr, bool) {
+	var v6 netip.Addr
+	for _, iface := range ifaces {
+		if len(p.AddressInterfaces) > 0 && !slices.Contains(p.AddressInterfaces, iface.name) {
+			continue
+		}
+		for _, addr := range iface.addrs {
+			addr = addr.Unmap()
+			if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+				continue
+			}
+			if len(p.AddressCIDRs) > 0 && !slices.ContainsFunc(p.AddressCIDRs, func(cidr netip.Prefix) bool {
+				return cidr.Contains(addr)
+			}) {
+				continue
+			}
+			if addr.Is4() {
+				return addr, true
+			}
+			if !v6.IsValid() {
+				v6 = addr
+			}
+		}
+	}
+	return v6, v6.IsValid()
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPickAddress(t *testing.T) {
+	ifaces := []guestInterface{
+		{name: "lo", addrs: []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}},
+		{name: "eth0", addrs: []netip.Addr{netip.MustParseAddr("fe80::1"), netip.MustParseAddr("fd00::50"), netip.MustParseAddr("192.168.1.50")}},
+		{name: "docker0", addrs: []netip.Addr{netip.MustParseAddr("172.17.0.1")}},
+	}
+
+	tests := []struct {
+		name       string
+		cidrs      []string
+		interfaces []string
+		expected   string
+	}{
+		{name: "First IPv4", expected: "192.168.1.50"},
+		{name: "CIDR Filter", cidrs: []string{"172.16.0.0/12"}, expected: "172.17.0.1"},
+		{name: "Interface Filter", interfaces: []string{"docker0"}, expected: "172.17.0.1"},
+		{name: "IPv6 When No IPv4 Matches", cidrs: []string{"fd00::/8"}, expected: "fd00::50"},
+		{name: "Loopback Is Never Used", interfaces: []string{"lo"}, expected: ""},
+		{name: "No Match", cidrs: []string{"10.0.0.0/8"}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &ProxmoxProvider{AddressInterfaces: tt.interfaces}
+			for _, cidr := range tt.cidrs {
+				p.AddressCIDRs = append(p.AddressCIDRs, netip.MustParsePrefix(cidr))
+			}
+
+			addr, ok := p.pickAddress(ifaces)
+			if tt.expected == "" {
+				if ok {
+					t.Errorf("Expected no address, got %s", addr)
+				}
+				return
+			}
+			if !ok || addr.String() != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, addr)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderDiscoverAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxType   string
+		path       string
+		interfaces string
+		expected   string
+	}{
+		{
+			name:       "QEMU Guest Agent",
+			proxType:   "qemu",
+			path:       "/api2/json/nodes/pve1/qemu/100/agent/network-get-interfaces",
+			interfaces: `{"data":{"result":[{"name":"lo","ip-addresses":[{"ip-address":"127.0.0.1","ip-address-type":"ipv4","prefix":8}]},{"name":"ens18","hardware-address":"bc:24:11:00:00:01","ip-addresses":[{"ip-address":"192.168.1.50","ip-address-type":"ipv4","prefix":24}]}]}}`,
+			expected:   "192.168.1.50",
+		},
+		{
+			name:       "LXC Interfaces",
+			proxType:   "lxc",
+			path:       "/api2/json/nodes/pve1/lxc/100/interfaces",
+			interfaces: `{"data":[{"name":"lo","inet":"127.0.0.1/8"},{"name":"eth0","hwaddr":"bc:24:11:00:00:02","inet":"192.168.1.51/24","inet6":"fe80::1/64"}]}`,
+			expected:   "192.168.1.51",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agentCalls := 0
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/" + tt.proxType + "/100/status/current":
+					w.Write([]byte(`{"data":{"status":"running"}}`))
+				case tt.path:
+					// The agent is not up yet on the first query.
+					agentCalls++
+					if agentCalls == 1 {
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write([]byte(`{"data":null,"message":"QEMU guest agent is not running"}`))
+						return
+					}
+					w.Write([]byte(tt.interfaces))
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:          server.URL + "/api2/json",
+				Node:            "pve1",
+				VMID:            "100",
+				Token:           "user@pam!token=secret",
+				Type:            tt.proxType,
+				Insecure:        true,
+				DiscoverAddress: true,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Status != WakeAlreadyRunning {
+				t.Errorf("Expected status %v, got %v", WakeAlreadyRunning, result.Status)
+			}
+			if result.Address != tt.expected {
+				t.Errorf("Expected address %s, got %q", tt.expected, result.Address)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// waitAgent polls the QEMU guest agent until it answers a ping, which means the
+// guest's OS has booted far enough to run services. Giving up after
+// AgentTimeout is not an error: the readiness probe still decides when the
+// target can be connected to.
+func (p *ProxmoxProvider) waitAgent(ctx context.Context) error {
+	agentCtx, cancel := context.WithTimeout(ctx, p.AgentTimeout)
+	defer cancel()
+
+	start := time.Now()
+	for {
+		err := p.pingAgent(agentCtx)
+		if err == nil {
+			log.Printf("Proxmox guest agent responded after %v", time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		select {
+		case <-agentCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("stopped waiting for the guest agent: %w", ctx.Err())
+			}
+			log.Printf("Warning: Proxmox guest agent did not respond within %v: %v", p.AgentTimeout, err)
+			return nil
+		case <-time.After(proxmoxTaskPollInterval):
+		}
+	}
+}
+
+// pingAgent sends a single agent/ping to the guest.
+func (p *ProxmoxProvider) pingAgent(ctx context.Context) error {
+	resp, err := p.makeRequest(ctx, "POST", "agent/ping", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("agent ping returned error %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProxmoxProviderWaitsForAgent(t *testing.T) {
+	tests := []struct {
+		name          string
+		proxType      string
+		agentTimeout  time.Duration
+		failingPings  int
+		expectedPings int
+	}{
+		{name: "Agent Answers", proxType: "qemu", agentTimeout: time.Minute, failingPings: 1, expectedPings: 2},
+		{name: "Agent Times Out", proxType: "qemu", agentTimeout: 50 * time.Millisecond, failingPings: 100, expectedPings: 1},
+		{name: "Disabled", proxType: "qemu", expectedPings: 0},
+		{name: "Containers Have No Agent", proxType: "lxc", agentTimeout: time.Minute, expectedPings: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pings := 0
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/" + tt.proxType + "/100/status/current":
+					w.Write([]byte(`{"data":{"status":"stopped"}}`))
+				case "/api2/json/nodes/pve1/" + tt.proxType + "/100/status/start":
+				case "/api2/json/nodes/pve1/qemu/100/agent/ping":
+					if r.Method != "POST" {
+						t.Errorf("Expected POST for agent ping, got %s", r.Method)
+					}
+					pings++
+					if pings <= tt.failingPings {
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write([]byte(`{"data":null,"message":"QEMU guest agent is not running"}`))
+						return
+					}
+					w.Write([]byte(`{"data":{"result":{}}}`))
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:       server.URL + "/api2/json",
+				Node:         "pve1",
+				VMID:         "100",
+				Token:        "user@pam!token=secret",
+				Type:         tt.proxType,
+				Insecure:     true,
+				AgentTimeout: tt.agentTimeout,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Status != WakeStarted {
+				t.Errorf("Expected status %v, got %v", WakeStarted, result.Status)
+			}
+			if pings != tt.expectedPings {
+				t.Errorf("Expected %d agent pings, got %d", tt.expectedPings, pings)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderAgentWaitCancellation(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/nodes/pve1/qemu/100/status/current":
+			w.Write([]byte(`{"data":{"status":"running"}}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:       server.URL + "/api2/json",
+		Node:         "pve1",
+		VMID:         "100",
+		Token:        "user@pam!token=secret",
+		Insecure:     true,
+		AgentTimeout: time.Minute,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result, err := provider.Wake(ctx)
+	if err == nil {
+		t.Fatal("Expected error for a cancelled wait, got nil")
+	}
+	if result.Status != WakeFailed {
+		t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+	}
+}
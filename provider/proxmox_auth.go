@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// proxmoxTicketLifetime is how long Proxmox accepts a login ticket.
+	proxmoxTicketLifetime = 2 * time.Hour
+	// proxmoxTicketRenewBefore is how long before its expiry a ticket is renewed.
+	proxmoxTicketRenewBefore = 15 * time.Minute
+)
+
+// ProxmoxTicketResponse is the response of /access/ticket.
+type ProxmoxTicketResponse struct {
+	Data struct {
+		Ticket              string `json:"ticket"`
+		CSRFPreventionToken string `json:"CSRFPreventionToken"`
+		// NeedTFA is set when the ticket is only a challenge that must be
+		// answered with a second factor.
+		NeedTFA int `json:"NeedTFA"`
+	} `json:"data"`
+}
+
+// proxmoxTicket is a cached login ticket.
+type proxmoxTicket struct {
+	ticket  string
+	csrf    string
+	expires time.Time
+}
+
+// authorize adds the API token, or the login ticket for username/password
+// logins, to a request.
+func (p *ProxmoxProvider) authorize(ctx context.Context, req *http.Request) error {
+	if p.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s", p.Token))
+		return nil
+	}
+
+	t, err := p.ticket(ctx)
+	if err != nil {
+		return err
+	}
+	req.AddCookie(&http.Cookie{Name: "PVEAuthCookie", Value: t.ticket})
+	if req.Method != http.MethodGet {
+		req.Header.Set("CSRFPreventionToken", t.csrf)
+	}
+	return nil
+}
+
+// ticket returns the cached login ticket, logging in again when there is none
+// or it is about to expire.
+func (p *ProxmoxProvider) ticket(ctx context.Context) (proxmoxTicket, error) {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+
+	if p.auth.ticket != "" && time.Until(p.auth.expires) > proxmoxTicketRenewBefore {
+		return p.auth, nil
+	}
+
+	t, err := p.login(ctx)
+	if err != nil {
+		return proxmoxTicket{}, fmt.Errorf("proxmox login failed: %w", err)
+	}
+	p.auth = t
+	return t, nil
+}
+
+// invalidateTicket drops the cached login ticket.
+func (p *ProxmoxProvider) invalidateTicket() {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	p.auth = proxmoxTicket{}
+}
+
+// login requests a new ticket with the username and password, answering the
+// two-factor challenge with a TOTP code if the account requires one.
+func (p *ProxmoxProvider) login(ctx context.Context) (proxmoxTicket, error) {
+	issued := time.Now()
+	resp, err := p.requestTicket(ctx, url.Values{
+		"username": {p.Username},
+		"password": {p.Password},
+	})
+	if err != nil {
+		return proxmoxTicket{}, err
+	}
+
+	if resp.Data.NeedTFA != 0 {
+		if p.TOTPSecret == "" {
+			return proxmoxTicket{}, fmt.Errorf("%s requires two-factor authentication, but no TOTP secret is configured", p.Username)
+		}
+		code, err := totpCode(p.TOTPSecret, time.Now())
+		if err != nil {
+			return proxmoxTicket{}, err
+		}
+		resp, err = p.requestTicket(ctx, url.Values{
+			"username":      {p.Username},
+			"tfa-challenge": {resp.Data.Ticket},
+			"password":      {"totp:" + code},
+		})
+		if err != nil {
+			return proxmoxTicket{}, fmt.Errorf("two-factor authentication failed: %w", err)
+		}
+		if resp.Data.NeedTFA != 0 {
+			return proxmoxTicket{}, fmt.Errorf("two-factor authentication was not accepted")
+		}
+	}
+
+	log.Printf("Logged in to Proxmox as %s", p.Username)
+	return proxmoxTicket{
+		ticket:  resp.Data.Ticket,
+		csrf:    resp.Data.CSRFPreventionToken,
+		expires: issued.Add(proxmoxTicketLifetime),
+	}, nil
+}
+
+// requestTicket POSTs credentials to /access/ticket.
+func (p *ProxmoxProvider) requestTicket(ctx context.Context, params url.Values) (ProxmoxTicketResponse, error) {
+	var ticketResp ProxmoxTicketResponse
+
+	resp, err := p.send(ctx, "POST", "/access/ticket", params, nil)
+	if err != nil {
+		return ticketResp, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ticketResp, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ticketResp, fmt.Errorf("proxmox api returned error %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, &ticketResp); err != nil {
+		return ticketResp, fmt.Errorf("failed to parse response json: %w", err)
+	}
+	if ticketResp.Data.Ticket == "" {
+		return ticketResp, fmt.Errorf("no ticket in response: %s", string(body))
+	}
+	return ticketResp, nil
+}
+
+// ParseTOTPSecret decodes a base32 TOTP secret as shown by authenticator
+// setups, ignoring case, spaces and padding.
+func ParseTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("invalid TOTP secret: empty")
+	}
+	return key, nil
+}
+
+// totpCode returns the 6-digit RFC 6238 code of a secret at time t, using
+// 30 second steps and HMAC-SHA1 like Proxmox and authenticator apps.
+func totpCode(secret string, t time.Time) (string, error) {
+	key, err := ParseTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
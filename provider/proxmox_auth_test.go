@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors for the SHA1 secret "12345678901234567890",
+	// truncated to 6 digits.
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.unix), func(t *testing.T) {
+			code, err := totpCode(secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if code != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	tests := []struct {
+		name        string
+		secret      string
+		expectError bool
+	}{
+		{name: "Valid", secret: "GEZDGNBVGY3TQOJQ"},
+		{name: "Lowercase With Spaces", secret: "gezd gnbv gy3t qojq"},
+		{name: "Padded", secret: "GEZDGNBVGY3TQOJQGE======"},
+		{name: "Invalid Characters", secret: "not-base32!", expectError: true},
+		{name: "Empty", secret: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTOTPSecret(tt.secret)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// ticketServer is a Proxmox API that requires a login ticket. Every login
+// issues a new ticket and only the latest one is accepted.
+type ticketServer struct {
+	t          *testing.T
+	totpSecret string
+	logins     int
+	ticket     string
+}
+
+func (s *ticketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api2/json/access/ticket" {
+		s.login(w, r)
+		return
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		s.t.Errorf("Expected no Authorization header with ticket auth, got %q", r.Header.Get("Authorization"))
+	}
+	cookie, err := r.Cookie("PVEAuthCookie")
+	if err != nil || cookie.Value != s.ticket {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" && r.Header.Get("CSRFPreventionToken") != "csrf-"+s.ticket {
+		s.t.Errorf("Expected CSRF token for %s, got %q", s.ticket, r.Header.Get("CSRFPreventionToken"))
+	}
+
+	switch r.URL.Path {
+	case "/api2/json/nodes/pve1/qemu/100/status/current":
+		w.Write([]byte(`{"data":{"status":"stopped"}}`))
+	case "/api2/json/nodes/pve1/qemu/100/status/start":
+	default:
+		s.t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *ticketServer) login(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("username") != "mop@pve" {
+		s.t.Errorf("Unexpected username %q", r.PostFormValue("username"))
+	}
+
+	if challenge := r.PostFormValue("tfa-challenge"); challenge != "" {
+		code := strings.TrimPrefix(r.PostFormValue("password"), "totp:")
+		expected, _ := totpCode(s.totpSecret, time.Now())
+		if challenge != "PVE:mop@pve:!tfa!challenge" || code != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	} else {
+		if r.PostFormValue("password") != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if s.totpSecret != "" {
+			w.Write([]byte(`{"data":{"ticket":"PVE:mop@pve:!tfa!challenge","NeedTFA":1}}`))
+			return
+		}
+	}
+
+	s.logins++
+	s.ticket = fmt.Sprintf("PVE:mop@pve:ticket-%d", s.logins)
+	fmt.Fprintf(w, `{"data":{"ticket":"%s","CSRFPreventionToken":"csrf-%s","username":"mop@pve"}}`, s.ticket, s.ticket)
+}
+
+func TestProxmoxTicketAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		totpSecret string
+	}{
+		{name: "Password"},
+		{name: "Password And TOTP", totpSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &ticketServer{t: t, totpSecret: tt.totpSecret}
+			server := httptest.NewTLSServer(api)
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:     server.URL + "/api2/json",
+				Node:       "pve1",
+				VMID:       "100",
+				Username:   "mop@pve",
+				Password:   "hunter2",
+				TOTPSecret: tt.totpSecret,
+				Insecure:   true,
+			}
+
+			for range 2 {
+				if _, err := provider.Wake(context.Background()); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if api.logins != 1 {
+				t.Errorf("Expected the ticket to be reused, got %d logins", api.logins)
+			}
+
+			// A ticket close to its expiry is renewed before it is used.
+			provider.auth.expires = time.Now().Add(proxmoxTicketRenewBefore - time.Minute)
+			if _, err := provider.Wake(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if api.logins != 2 {
+				t.Errorf("Expected the ticket to be renewed, got %d logins", api.logins)
+			}
+		})
+	}
+}
+
+func TestProxmoxTicketAuthRejectedTicket(t *testing.T) {
+	api := &ticketServer{t: t}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		Node:     "pve1",
+		VMID:     "100",
+		Username: "mop@pve",
+		Password: "hunter2",
+		Insecure: true,
+	}
+	provider.auth = proxmoxTicket{ticket: "PVE:mop@pve:revoked", csrf: "csrf", expires: time.Now().Add(time.Hour)}
+
+	if _, err := provider.Wake(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if api.logins != 1 {
+		t.Errorf("Expected a new login after the ticket was rejected, got %d logins", api.logins)
+	}
+}
+
+func TestProxmoxTicketAuthErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		password    string
+		totpSecret  string
+		serverTOTP  string
+		expectError string
+	}{
+		{name: "Wrong Password", password: "wrong", expectError: "proxmox login failed: proxmox api returned error 401"},
+		{name: "Missing TOTP Secret", password: "hunter2", serverTOTP: "GEZDGNBVGY3TQOJQ", expectError: "requires two-factor authentication"},
+		{name: "Wrong TOTP Secret", password: "hunter2", totpSecret: "MFRGGZDFMZTWQ2LK", serverTOTP: "GEZDGNBVGY3TQOJQ", expectError: "two-factor authentication failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &ticketServer{t: t, totpSecret: tt.serverTOTP}
+			server := httptest.NewTLSServer(api)
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:     server.URL + "/api2/json",
+				Node:       "pve1",
+				VMID:       "100",
+				Username:   "mop@pve",
+				Password:   tt.password,
+				TOTPSecret: tt.totpSecret,
+				Insecure:   true,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("Expected error containing %q, got %v", tt.expectError, err)
+			}
+			if result.Status != WakeFailed {
+				t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpClient returns the provider's HTTP client. It is built once from the
+// provider's settings and reused by every request, so connections to the API
+// are kept alive between status checks, task polls and wakes.
+func (p *ProxmoxProvider) httpClient() (*http.Client, error) {
+	p.clientOnce.Do(func() {
+		tlsConfig, err := p.tlsConfig()
+		if err != nil {
+			p.clientErr = fmt.Errorf("invalid TLS settings: %w", err)
+			return
+		}
+
+		timeout := p.Timeout
+		if timeout <= 0 {
+			timeout = defaultProxmoxTimeout
+		}
+
+		transport := &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: proxmoxMaxIdleConns,
+			IdleConnTimeout:     proxmoxIdleConnTimeout,
+		}
+
+		p.client = &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return fmt.Errorf("stopped after 10 redirects")
+				}
+				if len(via) > 0 {
+					lastReq := via[len(via)-1]
+					for _, header := range []string{"Authorization", "Cookie", "CSRFPreventionToken"} {
+						if value := lastReq.Header.Get(header); value != "" {
+							req.Header.Set(header, value)
+						}
+					}
+				}
+				return nil
+			},
+		}
+	})
+	return p.client, p.clientErr
+}
+
+// Close closes the idle connections to the Proxmox API. The provider can still
+// be used afterwards, but needs to connect again.
+func (p *ProxmoxProvider) Close() error {
+	if client, err := p.httpClient(); err == nil {
+		client.CloseIdleConnections()
+	}
+	return nil
+}
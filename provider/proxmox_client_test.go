@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxmoxProviderReusesConnections(t *testing.T) {
+	var mu sync.Mutex
+	states := map[http.ConnState]int{}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"status":"running"}}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		states[state]++
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		Node:     "pve1",
+		VMID:     "100",
+		Token:    "user@pam!token=secret",
+		Insecure: true,
+	}
+
+	for range 5 {
+		if _, err := provider.Wake(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := provider.Sleep(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	mu.Lock()
+	opened := states[http.StateNew]
+	mu.Unlock()
+	if opened != 1 {
+		t.Errorf("Expected all requests to share 1 connection, got %d connections", opened)
+	}
+
+	provider.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		closed := states[http.StateClosed]
+		mu.Unlock()
+		if closed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected Close to close the idle connection, got %d closed", closed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
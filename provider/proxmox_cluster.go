@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ProxmoxClusterResource is a guest as listed by /cluster/resources?type=vm.
+type ProxmoxClusterResource struct {
+	VMID   int    `json:"vmid"`
+	Name   string `json:"name"`
+	Node   string `json:"node"`
+	Type   string `json:"type"` // "qemu" or "lxc"
+	Status string `json:"status"`
+	Tags   string `json:"tags"` // separated by ";"
+}
+
+// ProxmoxClusterResourcesResponse is the response of /cluster/resources?type=vm.
+type ProxmoxClusterResourcesResponse struct {
+	Data []ProxmoxClusterResource `json:"data"`
+}
+
+// GuestMatchError reports that the configured VMID, name and tag match no
+// guest in the cluster, or more than one. Unlike an unreachable API, asking
+// again won't help until the cluster or the configuration changes.
+type GuestMatchError struct {
+	msg string
+}
+
+func (e *GuestMatchError) Error() string {
+	return e.msg
+}
+
+// proxmoxGuest is where the guest was last found in the cluster.
+type proxmoxGuest struct {
+	vmid string
+	node string
+	typ  string
+}
+
+// guest returns the VMID, node and type of the guest, preferring what the last
+// cluster lookup found over the configured VMID, Node and Type.
+func (p *ProxmoxProvider) guest() proxmoxGuest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resolved.node != "" {
+		return p.resolved
+	}
+
+	typ := p.Type
+	if typ == "" {
+		typ = "qemu"
+	}
+	return proxmoxGuest{vmid: p.VMID, node: p.Node, typ: typ}
+}
+
+// Resolve looks the guest up in the cluster resources. It is called at startup
+// for guests configured by name or tag, so a missing or ambiguous match, a
+// *GuestMatchError, is reported before any traffic arrives.
+func (p *ProxmoxProvider) Resolve(ctx context.Context) error {
+	_, err := p.resolveGuest(ctx)
+	return err
+}
+
+// resolveGuest looks the guest up in the cluster resources and caches its
+// VMID, node and type, so guests that were migrated to another node or rebuilt
+// under a new VMID are still found. It reports whether the guest changed since
+// the last lookup.
+func (p *ProxmoxProvider) resolveGuest(ctx context.Context) (bool, error) {
+	var resources ProxmoxClusterResourcesResponse
+	if err := p.getJSON(ctx, "/cluster/resources?type=vm", &resources); err != nil {
+		return false, fmt.Errorf("failed to list cluster resources: %w", err)
+	}
+
+	found, err := p.findGuest(resources.Data)
+	if err != nil {
+		return false, err
+	}
+
+	previous := p.guest()
+	current := proxmoxGuest{vmid: strconv.Itoa(found.VMID), node: found.Node, typ: found.Type}
+
+	p.mu.Lock()
+	p.resolved = current
+	p.mu.Unlock()
+
+	if current != previous {
+		log.Printf("Proxmox %s is %s %s on node %s", p.selector(), current.typ, current.vmid, current.node)
+	}
+	return current != previous, nil
+}
+
+// findGuest returns the only cluster resource that matches every configured
+// selector: VMID, Name and Tag.
+func (p *ProxmoxProvider) findGuest(resources []ProxmoxClusterResource) (ProxmoxClusterResource, error) {
+	vmid := -1
+	if p.VMID != "" {
+		var err error
+		if vmid, err = strconv.Atoi(p.VMID); err != nil {
+			return ProxmoxClusterResource{}, fmt.Errorf("invalid VMID %q: %w", p.VMID, err)
+		}
+	}
+
+	var matches []ProxmoxClusterResource
+	for _, r := range resources {
+		if r.Type != "qemu" && r.Type != "lxc" {
+			continue
+		}
+		if vmid >= 0 && r.VMID != vmid {
+			continue
+		}
+		if p.Name != "" && r.Name != p.Name {
+			continue
+		}
+		if p.Tag != "" && !hasTag(r.Tags, p.Tag) {
+			continue
+		}
+		matches = append(matches, r)
+	}
+
+	switch len(matches) {
+	case 0:
+		return ProxmoxClusterResource{}, &GuestMatchError{msg: fmt.Sprintf("no %s found in the cluster", p.selector())}
+	case 1:
+		return matches[0], nil
+	default:
+		found := make([]string, len(matches))
+		for i, r := range matches {
+			found[i] = fmt.Sprintf("%s %d %q on %s", r.Type, r.VMID, r.Name, r.Node)
+		}
+		return ProxmoxClusterResource{}, &GuestMatchError{msg: fmt.Sprintf("%s is ambiguous, it matches %s", p.selector(), strings.Join(found, ", "))}
+	}
+}
+
+// selector describes how the guest is configured, for log and error messages.
+func (p *ProxmoxProvider) selector() string {
+	var parts []string
+	if p.VMID != "" {
+		parts = append(parts, "VMID "+p.VMID)
+	}
+	if p.Name != "" {
+		parts = append(parts, fmt.Sprintf("name %q", p.Name))
+	}
+	if p.Tag != "" {
+		parts = append(parts, fmt.Sprintf("tag %q", p.Tag))
+	}
+	return "guest with " + strings.Join(parts, " and ")
+}
+
+// hasTag reports whether a Proxmox tag list, e.g. "mop;gpu", contains tag.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	}) {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
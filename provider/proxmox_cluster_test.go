@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testClusterResources = `{"data":[
+	{"vmid":100,"name":"gpu-box","node":"pve2","type":"qemu","status":"stopped"},
+	{"vmid":101,"name":"nas","node":"pve1","type":"lxc","status":"running","tags":"mop;storage"},
+	{"vmid":102,"name":"build","node":"pve1","type":"qemu","status":"stopped","tags":"ci"},
+	{"vmid":103,"name":"build","node":"pve2","type":"qemu","status":"stopped","tags":"ci;mop"},
+	{"id":"storage/pve1/local","node":"pve1","type":"storage"}
+]}`
+
+func TestProxmoxProviderNodeDiscovery(t *testing.T) {
+	tests := []struct {
+		name                 string
+		node                 string
+		expectedLookups      int
+		expectedStatusChecks map[string]int
+	}{
+		{
+			name:                 "Migrated Guest",
+			node:                 "pve1",
+			expectedLookups:      1,
+			expectedStatusChecks: map[string]int{"pve1": 1, "pve2": 2},
+		},
+		{
+			name:                 "No Node Configured",
+			node:                 "",
+			expectedLookups:      1,
+			expectedStatusChecks: map[string]int{"pve2": 2},
+		},
+		{
+			name:                 "Guest On Configured Node",
+			node:                 "pve2",
+			expectedLookups:      0,
+			expectedStatusChecks: map[string]int{"pve2": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := 0
+			statusChecks := map[string]int{}
+			starts := 0
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/cluster/resources":
+					if r.URL.Query().Get("type") != "vm" {
+						t.Errorf("Expected type=vm, got %q", r.URL.RawQuery)
+					}
+					lookups++
+					w.Write([]byte(testClusterResources))
+				case "/api2/json/nodes/pve1/qemu/100/status/current":
+					statusChecks["pve1"]++
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`{"data":null,"message":"Configuration file 'nodes/pve1/qemu-server/100.conf' does not exist"}`))
+				case "/api2/json/nodes/pve2/qemu/100/status/current":
+					statusChecks["pve2"]++
+					w.Write([]byte(`{"data":{"status":"stopped"}}`))
+				case "/api2/json/nodes/pve2/qemu/100/status/start":
+					starts++
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:   server.URL + "/api2/json",
+				Node:     tt.node,
+				VMID:     "100",
+				Token:    "user@pam!token=secret",
+				Insecure: true,
+			}
+
+			// The second wake must use the cached node.
+			for range 2 {
+				if _, err := provider.Wake(context.Background()); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			if lookups != tt.expectedLookups {
+				t.Errorf("Expected %d cluster lookups, got %d", tt.expectedLookups, lookups)
+			}
+			for node, expected := range tt.expectedStatusChecks {
+				if statusChecks[node] != expected {
+					t.Errorf("Expected %d status checks on %s, got %d", expected, node, statusChecks[node])
+				}
+			}
+			if starts != 2 {
+				t.Errorf("Expected 2 starts on pve2, got %d", starts)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderGuestNotInCluster(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/cluster/resources" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(testClusterResources))
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		VMID:     "200",
+		Token:    "user@pam!token=secret",
+		Insecure: true,
+	}
+
+	result, err := provider.Wake(context.Background())
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if result.Status != WakeFailed {
+		t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+	}
+}
+
+func TestProxmoxProviderResolve(t *testing.T) {
+	tests := []struct {
+		name          string
+		vmid          string
+		guestName     string
+		tag           string
+		expectedGuest proxmoxGuest
+		expectedErr   string
+	}{
+		{
+			name:          "By Name",
+			guestName:     "nas",
+			expectedGuest: proxmoxGuest{vmid: "101", node: "pve1", typ: "lxc"},
+		},
+		{
+			name:          "By Tag",
+			tag:           "storage",
+			expectedGuest: proxmoxGuest{vmid: "101", node: "pve1", typ: "lxc"},
+		},
+		{
+			name:          "By Name And Tag",
+			guestName:     "build",
+			tag:           "mop",
+			expectedGuest: proxmoxGuest{vmid: "103", node: "pve2", typ: "qemu"},
+		},
+		{
+			name:        "Ambiguous Name",
+			guestName:   "build",
+			expectedErr: `guest with name "build" is ambiguous, it matches qemu 102 "build" on pve1, qemu 103 "build" on pve2`,
+		},
+		{
+			name:        "Ambiguous Tag",
+			tag:         "mop",
+			expectedErr: `guest with tag "mop" is ambiguous`,
+		},
+		{
+			name:        "Missing Name",
+			guestName:   "gone",
+			expectedErr: `no guest with name "gone" found in the cluster`,
+		},
+		{
+			name:        "Name Does Not Match VMID",
+			vmid:        "100",
+			guestName:   "nas",
+			expectedErr: `no guest with VMID 100 and name "nas" found in the cluster`,
+		},
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testClusterResources))
+	}))
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &ProxmoxProvider{
+				APIURL:   server.URL + "/api2/json",
+				VMID:     tt.vmid,
+				Name:     tt.guestName,
+				Tag:      tt.tag,
+				Token:    "user@pam!token=secret",
+				Insecure: true,
+			}
+
+			err := provider.Resolve(context.Background())
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if guest := provider.guest(); guest != tt.expectedGuest {
+				t.Errorf("Expected guest %+v, got %+v", tt.expectedGuest, guest)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderRebuiltGuest(t *testing.T) {
+	resources := `{"data":[{"vmid":100,"name":"gpu-box","node":"pve1","type":"qemu"}]}`
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/json/cluster/resources":
+			w.Write([]byte(resources))
+		case "/api2/json/nodes/pve1/qemu/100/status/current":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/api2/json/nodes/pve1/qemu/120/status/current":
+			w.Write([]byte(`{"data":{"status":"running","qmpstatus":"running"}}`))
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		Name:     "gpu-box",
+		Token:    "user@pam!token=secret",
+		Insecure: true,
+	}
+	if err := provider.Resolve(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The guest is rebuilt under a new VMID.
+	resources = `{"data":[{"vmid":120,"name":"gpu-box","node":"pve1","type":"qemu"}]}`
+
+	result, err := provider.Wake(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Status != WakeAlreadyRunning {
+		t.Errorf("Expected status %v, got %v", WakeAlreadyRunning, result.Status)
+	}
+}
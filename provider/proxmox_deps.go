@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Readiness checks of a ProxmoxDependency.
+const (
+	ProxmoxReadyRunning = "running" // the guest runs and is not paused
+	ProxmoxReadyAgent   = "agent"   // the QEMU guest agent answers a ping
+	ProxmoxReadyTCP     = "tcp"     // Address accepts TCP connections
+)
+
+// ProxmoxDependency is a guest that is woken, and waited for, before the
+// provider's own guest, e.g. a storage container an app VM mounts at boot.
+// It is selected like the provider's guest and reached through the same API.
+type ProxmoxDependency struct {
+	VMID string
+	Name string
+	Tag  string
+	Type string // "qemu" or "lxc", looked up in the cluster if wrong
+
+	// Ready is how to tell that the guest is ready: ProxmoxReadyRunning (the
+	// default), ProxmoxReadyAgent or ProxmoxReadyTCP, which dials Address.
+	Ready   string
+	Address string
+	// Timeout bounds waking the guest and waiting for it to be ready.
+	// Defaults to the provider's TaskTimeout.
+	Timeout time.Duration
+}
+
+// dependencies returns a provider for each of DependsOn, sharing this
+// provider's API connection and login. They are created once, so that
+// the guests' locations are cached across wakes.
+func (p *ProxmoxProvider) dependencies() []*ProxmoxProvider {
+	p.depsOnce.Do(func() {
+		for _, d := range p.DependsOn {
+			p.deps = append(p.deps, &ProxmoxProvider{
+				APIURL:      p.APIURL,
+				Node:        p.Node,
+				VMID:        d.VMID,
+				Name:        d.Name,
+				Tag:         d.Tag,
+				Type:        d.Type,
+				TaskTimeout: p.TaskTimeout,
+				parent:      p,
+			})
+		}
+	})
+	return p.deps
+}
+
+// wakeDependencies wakes the guests of DependsOn in order, waiting for each to
+// be ready before moving on to the next. A failure names the guest that failed
+// and the guests that were already woken.
+func (p *ProxmoxProvider) wakeDependencies(ctx context.Context) error {
+	deps := p.dependencies()
+	var woken []string
+	for i, dep := range deps {
+		status, err := p.wakeDependency(ctx, dep, p.DependsOn[i])
+		if err != nil {
+			err = fmt.Errorf("dependency %d of %d, %s, failed: %w", i+1, len(deps), dep.selector(), err)
+			if len(woken) > 0 {
+				err = fmt.Errorf("%w (already woken: %s)", err, strings.Join(woken, ", "))
+			}
+			return err
+		}
+		woken = append(woken, fmt.Sprintf("%s (%v)", dep.selector(), status))
+	}
+	return nil
+}
+
+// wakeDependency wakes a single dependency and waits until it is ready.
+func (p *ProxmoxProvider) wakeDependency(ctx context.Context, dep *ProxmoxProvider, d ProxmoxDependency) (WakeStatus, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = p.TaskTimeout
+	}
+	if timeout <= 0 {
+		timeout = defaultProxmoxTaskTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("Waking Proxmox dependency %s", dep.selector())
+	result, err := dep.wake(ctx)
+	if err != nil {
+		return result.Status, err
+	}
+
+	ready := d.Ready
+	if ready == "" {
+		ready = ProxmoxReadyRunning
+	}
+	for {
+		err := dep.checkReady(ctx, ready, d.Address)
+		if err == nil {
+			log.Printf("Proxmox dependency %s is %v and ready (%s)", dep.selector(), result.Status, ready)
+			return result.Status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return result.Status, fmt.Errorf("%v but not ready (%s) within %v: %w", result.Status, ready, timeout, err)
+		case <-time.After(proxmoxTaskPollInterval):
+		}
+	}
+}
+
+// checkReady runs a single readiness check of a dependency.
+func (p *ProxmoxProvider) checkReady(ctx context.Context, ready, address string) error {
+	switch ready {
+	case ProxmoxReadyRunning:
+		st, err := p.status(ctx)
+		if err != nil {
+			return err
+		}
+		if st.Status != "running" || st.paused() {
+			return fmt.Errorf("guest is %s", orNone(st.QMPStatus))
+		}
+		return nil
+	case ProxmoxReadyAgent:
+		return p.pingAgent(ctx)
+	case ProxmoxReadyTCP:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		return fmt.Errorf("unknown readiness check %q", ready)
+	}
+}
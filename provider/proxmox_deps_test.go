@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dependencyServer is a Proxmox API with stopped guests on node pve1 that
+// records the order in which they are started.
+type dependencyServer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	running map[string]bool // "lxc/105" -> running
+	failing map[string]bool // guests whose start fails
+	started []string        // guests in start order
+}
+
+func (s *dependencyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api2/json/nodes/pve1/")
+	guest, endpoint, _ := strings.Cut(path, "/status/")
+	if _, ok := s.running[guest]; !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"data":null,"message":"Configuration file does not exist"}`))
+		return
+	}
+
+	switch endpoint {
+	case "current":
+		if s.running[guest] {
+			w.Write([]byte(`{"data":{"status":"running"}}`))
+		} else {
+			w.Write([]byte(`{"data":{"status":"stopped"}}`))
+		}
+	case "start":
+		if s.failing[guest] {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"data":null,"message":"storage 'nfs' is not online"}`))
+			return
+		}
+		s.running[guest] = true
+		s.started = append(s.started, guest)
+	default:
+		s.t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func TestProxmoxProviderDependencies(t *testing.T) {
+	nfs, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer nfs.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name            string
+		dependsOn       []ProxmoxDependency
+		failing         map[string]bool
+		expectedStarted []string
+		expectError     []string
+	}{
+		{
+			name: "Dependencies Start In Order",
+			dependsOn: []ProxmoxDependency{
+				{VMID: "105", Type: "lxc"},
+				{VMID: "106", Ready: ProxmoxReadyTCP, Address: nfs.Addr().String()},
+			},
+			expectedStarted: []string{"lxc/105", "qemu/106", "qemu/110"},
+		},
+		{
+			name: "Failing Dependency Stops The Wake",
+			dependsOn: []ProxmoxDependency{
+				{VMID: "105", Type: "lxc"},
+				{VMID: "106"},
+			},
+			failing:         map[string]bool{"qemu/106": true},
+			expectedStarted: []string{"lxc/105"},
+			expectError: []string{
+				"dependency 2 of 2, guest with VMID 106, failed",
+				"storage 'nfs' is not online",
+				"already woken: guest with VMID 105 (started)",
+			},
+		},
+		{
+			name: "Dependency Not Ready In Time",
+			dependsOn: []ProxmoxDependency{
+				{VMID: "105", Type: "lxc", Ready: ProxmoxReadyTCP, Address: closedAddr, Timeout: 50 * time.Millisecond},
+			},
+			expectedStarted: []string{"lxc/105"},
+			expectError:     []string{"dependency 1 of 1, guest with VMID 105, failed: started but not ready (tcp) within 50ms"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &dependencyServer{
+				t:       t,
+				running: map[string]bool{"lxc/105": false, "qemu/106": false, "qemu/110": false},
+				failing: tt.failing,
+			}
+			server := httptest.NewTLSServer(api)
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:    server.URL + "/api2/json",
+				Node:      "pve1",
+				VMID:      "110",
+				Token:     "user@pam!token=secret",
+				Insecure:  true,
+				DependsOn: tt.dependsOn,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if len(tt.expectError) == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if result.Status != WakeStarted {
+					t.Errorf("Expected status %v, got %v", WakeStarted, result.Status)
+				}
+			} else {
+				if err == nil {
+					t.Fatal("Expected error, got nil")
+				}
+				for _, expected := range tt.expectError {
+					if !strings.Contains(err.Error(), expected) {
+						t.Errorf("Expected error containing %q, got %q", expected, err)
+					}
+				}
+				if result.Status != WakeFailed {
+					t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+				}
+			}
+
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			if strings.Join(api.started, ",") != strings.Join(tt.expectedStarted, ",") {
+				t.Errorf("Expected guests started in order %v, got %v", tt.expectedStarted, api.started)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultProxmoxTaskTimeout bounds how long to wait for a task when no TaskTimeout is configured.
+	defaultProxmoxTaskTimeout = 2 * time.Minute
+	// proxmoxTaskPollInterval is the delay between task status checks.
+	proxmoxTaskPollInterval = time.Second
+	// proxmoxTaskLogLines is how many lines of a failed task's log are reported.
+	proxmoxTaskLogLines = 10
+)
+
+// ProxmoxTaskStatusResponse is the response of /nodes/{node}/tasks/{upid}/status.
+type ProxmoxTaskStatusResponse struct {
+	Data struct {
+		Status     string `json:"status"`
+		ExitStatus string `json:"exitstatus"`
+	} `json:"data"`
+}
+
+// ProxmoxTaskLogResponse is the response of /nodes/{node}/tasks/{upid}/log.
+type ProxmoxTaskLogResponse struct {
+	Data []struct {
+		N int    `json:"n"`
+		T string `json:"t"`
+	} `json:"data"`
+	Total int `json:"total"` // lines in the whole log
+}
+
+// getJSON performs a GET request for an API path and decodes the JSON response into v.
+func (p *ProxmoxProvider) getJSON(ctx context.Context, path string, v any) error {
+	resp, err := p.apiRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxmox api returned error %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse response json: %w", err)
+	}
+	return nil
+}
+
+// runTask POSTs to an endpoint of the VM/CT that starts a task, e.g. "status/start",
+// and waits for the task to finish.
+func (p *ProxmoxProvider) runTask(ctx context.Context, endpoint string, params url.Values) error {
+	resp, err := p.makeRequest(ctx, "POST", endpoint, params)
+	if err != nil {
+		return fmt.Errorf("api call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("api returned error %d: %s", resp.StatusCode, string(body))
+	}
+
+	var taskResp ProxmoxTaskResponse
+	if err := json.Unmarshal(body, &taskResp); err != nil || taskResp.Data == "" {
+		log.Printf("Warning: Proxmox %s returned no task ID, not waiting for it to finish: %s", endpoint, string(body))
+		return nil
+	}
+
+	return p.waitTask(ctx, taskResp.Data)
+}
+
+// waitTask polls a task until it finishes. A task that finishes with an exit
+// status other than "OK" is returned as an error including the tail of its log.
+func (p *ProxmoxProvider) waitTask(ctx context.Context, upid string) error {
+	timeout := p.TaskTimeout
+	if timeout <= 0 {
+		timeout = defaultProxmoxTaskTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	taskPath := fmt.Sprintf("/nodes/%s/tasks/%s", taskNode(upid, p.guest().node), url.PathEscape(upid))
+	log.Printf("Waiting for Proxmox task %s", upid)
+
+	for {
+		var status ProxmoxTaskStatusResponse
+		if err := p.getJSON(ctx, taskPath+"/status", &status); err != nil {
+			return fmt.Errorf("failed to check task %s: %w", upid, err)
+		}
+
+		if status.Data.Status == "stopped" {
+			if status.Data.ExitStatus == "OK" {
+				log.Printf("Proxmox task %s finished", upid)
+				return nil
+			}
+			return fmt.Errorf("task %s failed: %s%s", upid, status.Data.ExitStatus, p.taskLogTail(ctx, taskPath))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("task %s did not finish: %w", upid, ctx.Err())
+		case <-time.After(proxmoxTaskPollInterval):
+		}
+	}
+}
+
+// taskLogTail returns the last lines of a task's log, formatted to be appended
+// to an error message. Failing to fetch the log is not an error of its own.
+func (p *ProxmoxProvider) taskLogTail(ctx context.Context, taskPath string) string {
+	var taskLog ProxmoxTaskLogResponse
+	if err := p.getJSON(ctx, fmt.Sprintf("%s/log?limit=%d", taskPath, proxmoxTaskLogLines), &taskLog); err != nil {
+		log.Printf("Failed to fetch Proxmox task log: %v", err)
+		return ""
+	}
+	// The log is read from its start, so a longer one is read again from its end
+	if taskLog.Total > len(taskLog.Data) {
+		start := taskLog.Total - proxmoxTaskLogLines
+		if err := p.getJSON(ctx, fmt.Sprintf("%s/log?start=%d&limit=%d", taskPath, start, proxmoxTaskLogLines), &taskLog); err != nil {
+			log.Printf("Failed to fetch Proxmox task log: %v", err)
+			return ""
+		}
+	}
+
+	lines := make([]string, 0, len(taskLog.Data))
+	for _, line := range taskLog.Data {
+		if line.T != "" {
+			lines = append(lines, line.T)
+		}
+	}
+	if len(lines) > proxmoxTaskLogLines {
+		lines = lines[len(lines)-proxmoxTaskLogLines:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// taskNode returns the node a task runs on, which is the second field of its
+// UPID, e.g. "UPID:pve1:000A1B2C:...". It falls back to node for malformed UPIDs.
+func taskNode(upid, node string) string {
+	parts := strings.Split(upid, ":")
+	if len(parts) > 2 && parts[0] == "UPID" && parts[1] != "" {
+		return parts[1]
+	}
+	return node
+}
@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProxmoxWakeWaitsForStartTask(t *testing.T) {
+	const upid = "UPID:pve2:0001E240:00A1B2C3:65F00000:qmstart:100:root@pam:"
+
+	tests := []struct {
+		name        string
+		taskStatus  string
+		expectError string
+	}{
+		{
+			name:       "Task Succeeds",
+			taskStatus: `{"data":{"status":"stopped","exitstatus":"OK"}}`,
+		},
+		{
+			name:        "Task Fails",
+			taskStatus:  `{"data":{"status":"stopped","exitstatus":"VM is locked (backup)"}}`,
+			expectError: "VM is locked (backup)\ntrying to acquire lock...\nTASK ERROR: VM is locked (backup)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusPolls := 0
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.EscapedPath() {
+				case "/api2/json/nodes/pve1/qemu/100/status/current":
+					w.Write([]byte(`{"data":{"status":"stopped"}}`))
+				case "/api2/json/nodes/pve1/qemu/100/status/start":
+					w.Write([]byte(`{"data":"` + upid + `"}`))
+				case "/api2/json/nodes/pve2/tasks/" + upid + "/status":
+					statusPolls++
+					if statusPolls == 1 {
+						w.Write([]byte(`{"data":{"status":"running"}}`))
+						return
+					}
+					w.Write([]byte(tt.taskStatus))
+				case "/api2/json/nodes/pve2/tasks/" + upid + "/log":
+					w.Write([]byte(`{"data":[{"n":1,"t":"trying to acquire lock..."},{"n":2,"t":"TASK ERROR: VM is locked (backup)"}]}`))
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.EscapedPath())
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:   server.URL + "/api2/json",
+				Node:     "pve1",
+				VMID:     "100",
+				Token:    "user@pam!token=secret",
+				Insecure: true,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if tt.expectError == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if result.Status != WakeStarted {
+					t.Errorf("Expected status %v, got %v", WakeStarted, result.Status)
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+					t.Fatalf("Expected error containing %q, got %v", tt.expectError, err)
+				}
+				if result.Status != WakeFailed {
+					t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+				}
+			}
+			if statusPolls != 2 {
+				t.Errorf("Expected the task to be polled until it stopped, got %d polls", statusPolls)
+			}
+		})
+	}
+}
+
+func TestTaskLogTail(t *testing.T) {
+	const total = 1500
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var lines []string
+		for n := start + 1; n <= min(start+limit, total); n++ {
+			lines = append(lines, fmt.Sprintf(`{"n":%d,"t":"line %d"}`, n, n))
+		}
+		fmt.Fprintf(w, `{"data":[%s],"total":%d}`, strings.Join(lines, ","), total)
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{APIURL: server.URL + "/api2/json", Token: "user@pam!token=secret", Insecure: true}
+	tail := provider.taskLogTail(context.Background(), "/nodes/pve1/tasks/UPID:pve1")
+
+	lines := strings.Split(strings.TrimPrefix(tail, "\n"), "\n")
+	if len(lines) != proxmoxTaskLogLines || lines[0] != "line 1491" || lines[len(lines)-1] != "line 1500" {
+		t.Errorf("Expected the last %d lines of the log, got %q", proxmoxTaskLogLines, tail)
+	}
+}
+
+func TestTaskNode(t *testing.T) {
+	tests := []struct {
+		upid string
+		want string
+	}{
+		{upid: "UPID:pve2:0001E240:00A1B2C3:65F00000:qmstart:100:root@pam:", want: "pve2"},
+		{upid: "not-a-upid", want: "fallback"},
+		{upid: "UPID::0001E240", want: "fallback"},
+	}
+
+	for _, tt := range tests {
+		if got := taskNode(tt.upid, "fallback"); got != tt.want {
+			t.Errorf("taskNode(%q) = %q, want %q", tt.upid, got, tt.want)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestProxmoxProvider(t *testing.T) {
@@ -170,13 +173,331 @@ func TestProxmoxProvider(t *testing.T) {
 				Insecure: true,
 			}
 
-			err := provider.Wake()
+			result, err := provider.Wake(context.Background())
+			if !tt.expectError && result.Status != WakeStarted {
+				t.Errorf("Expected status %v, got %v", WakeStarted, result.Status)
+			}
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderSleep(t *testing.T) {
+	tests := []struct {
+		name          string
+		currentStatus string
+		expectCall    bool
+	}{
+		{name: "Running VM Is Shut Down", currentStatus: "running", expectCall: true},
+		{name: "Stopped VM Is Left Alone", currentStatus: "stopped", expectCall: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdownCalled := false
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/qemu/100/status/current":
+					fmt.Fprintf(w, `{"data":{"status":"%s"}}`, tt.currentStatus)
+				case "/api2/json/nodes/pve1/qemu/100/status/shutdown":
+					if r.Method != "POST" {
+						t.Errorf("Expected POST for shutdown, got %s", r.Method)
+					}
+					shutdownCalled = true
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:   server.URL + "/api2/json",
+				Node:     "pve1",
+				VMID:     "100",
+				Token:    "user@pam!token=secret",
+				Insecure: true,
+			}
+
+			if err := provider.Sleep(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if shutdownCalled != tt.expectCall {
+				t.Errorf("Expected shutdown called=%v, got %v", tt.expectCall, shutdownCalled)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderAlreadyRunning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/nodes/pve1/qemu/100/status/current" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"data":{"status":"running"}}`))
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		Node:     "pve1",
+		VMID:     "100",
+		Token:    "user@pam!token=secret",
+		Insecure: true,
+	}
+
+	result, err := provider.Wake(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Status != WakeAlreadyRunning {
+		t.Errorf("Expected status %v, got %v", WakeAlreadyRunning, result.Status)
+	}
+}
+
+func TestProxmoxProviderCancellation(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	provider := &ProxmoxProvider{
+		APIURL:   server.URL + "/api2/json",
+		Node:     "pve1",
+		VMID:     "100",
+		Token:    "user@pam!token=secret",
+		Insecure: true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := provider.Wake(ctx)
+	if err == nil {
+		t.Fatal("Expected error for a cancelled wake, got nil")
+	}
+	if result.Status != WakeFailed {
+		t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected wake to stop when the context expired, took %v", elapsed)
+	}
+}
+
+func TestProxmoxProviderWakeActions(t *testing.T) {
+	tests := []struct {
+		name             string
+		statuses         []string // successive status/current responses
+		expectedEndpoint string
+		expectedStatus   WakeStatus
+	}{
+		{
+			name:             "Stopped VM Is Started",
+			statuses:         []string{`{"status":"stopped","qmpstatus":"stopped"}`},
+			expectedEndpoint: "status/start",
+			expectedStatus:   WakeStarted,
+		},
+		{
+			name:             "Paused VM Is Resumed",
+			statuses:         []string{`{"status":"running","qmpstatus":"paused"}`},
+			expectedEndpoint: "status/resume",
+			expectedStatus:   WakeStarted,
+		},
+		{
+			name:             "Suspended VM Is Resumed",
+			statuses:         []string{`{"status":"running","qmpstatus":"suspended"}`},
+			expectedEndpoint: "status/resume",
+			expectedStatus:   WakeStarted,
+		},
+		{
+			name:             "Hibernated VM Is Started",
+			statuses:         []string{`{"status":"stopped","qmpstatus":"stopped","lock":"suspended"}`},
+			expectedEndpoint: "status/start",
+			expectedStatus:   WakeStarted,
+		},
+		{
+			name: "Locked VM Is Started Once Unlocked",
+			statuses: []string{
+				`{"status":"stopped","qmpstatus":"stopped","lock":"backup"}`,
+				`{"status":"stopped","qmpstatus":"stopped"}`,
+			},
+			expectedEndpoint: "status/start",
+			expectedStatus:   WakeStarted,
+		},
+		{
+			name:           "Running Guest Is Left Alone",
+			statuses:       []string{`{"status":"running"}`},
+			expectedStatus: WakeAlreadyRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCalls := 0
+			var calledEndpoint string
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/qemu/100/status/current":
+					status := tt.statuses[min(statusCalls, len(tt.statuses)-1)]
+					statusCalls++
+					fmt.Fprintf(w, `{"data":%s}`, status)
+				case "/api2/json/nodes/pve1/qemu/100/status/start", "/api2/json/nodes/pve1/qemu/100/status/resume":
+					if r.Method != "POST" {
+						t.Errorf("Expected POST, got %s", r.Method)
+					}
+					calledEndpoint = strings.TrimPrefix(r.URL.Path, "/api2/json/nodes/pve1/qemu/100/")
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:   server.URL + "/api2/json",
+				Node:     "pve1",
+				VMID:     "100",
+				Token:    "user@pam!token=secret",
+				Insecure: true,
+			}
+
+			result, err := provider.Wake(context.Background())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Status != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v", tt.expectedStatus, result.Status)
+			}
+			if calledEndpoint != tt.expectedEndpoint {
+				t.Errorf("Expected %q to be called, got %q", tt.expectedEndpoint, calledEndpoint)
+			}
+			if statusCalls != len(tt.statuses) {
+				t.Errorf("Expected %d status checks, got %d", len(tt.statuses), statusCalls)
+			}
+		})
+	}
+}
+
+func TestProxmoxProviderWakeLockTimeout(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/nodes/pve1/qemu/100/status/current" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"data":{"status":"stopped","lock":"migrate"}}`))
+	}))
+	defer server.Close()
+
+	provider := &ProxmoxProvider{
+		APIURL:      server.URL + "/api2/json",
+		Node:        "pve1",
+		VMID:        "100",
+		Token:       "user@pam!token=secret",
+		Insecure:    true,
+		TaskTimeout: 50 * time.Millisecond,
+	}
+
+	result, err := provider.Wake(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "still locked (migrate)") {
+		t.Fatalf("Expected lock error, got %v", err)
+	}
+	if result.Status != WakeFailed {
+		t.Errorf("Expected status %v, got %v", WakeFailed, result.Status)
+	}
+}
+
+func TestProxmoxProviderSleepActions(t *testing.T) {
+	tests := []struct {
+		name             string
+		sleepAction      string
+		currentStatus    string
+		expectedEndpoint string
+		expectedTodisk   string
+		expectError      bool
+	}{
+		{
+			name:             "Default Shuts Down",
+			currentStatus:    `{"status":"running","qmpstatus":"running"}`,
+			expectedEndpoint: "status/shutdown",
+		},
+		{
+			name:             "Suspend",
+			sleepAction:      ProxmoxSleepSuspend,
+			currentStatus:    `{"status":"running","qmpstatus":"running"}`,
+			expectedEndpoint: "status/suspend",
+		},
+		{
+			name:          "Suspend Skips Paused VM",
+			sleepAction:   ProxmoxSleepSuspend,
+			currentStatus: `{"status":"running","qmpstatus":"paused"}`,
+		},
+		{
+			name:             "Hibernate",
+			sleepAction:      ProxmoxSleepHibernate,
+			currentStatus:    `{"status":"running","qmpstatus":"running"}`,
+			expectedEndpoint: "status/suspend",
+			expectedTodisk:   "1",
+		},
+		{
+			name:          "Locked VM",
+			sleepAction:   ProxmoxSleepHibernate,
+			currentStatus: `{"status":"running","qmpstatus":"running","lock":"backup"}`,
+			expectError:   true,
+		},
+		{
+			name:          "Unknown Action",
+			sleepAction:   "reboot",
+			currentStatus: `{"status":"running","qmpstatus":"running"}`,
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calledEndpoint, todisk string
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api2/json/nodes/pve1/qemu/100/status/current":
+					fmt.Fprintf(w, `{"data":%s}`, tt.currentStatus)
+				case "/api2/json/nodes/pve1/qemu/100/status/shutdown", "/api2/json/nodes/pve1/qemu/100/status/suspend":
+					if r.Method != "POST" {
+						t.Errorf("Expected POST, got %s", r.Method)
+					}
+					calledEndpoint = strings.TrimPrefix(r.URL.Path, "/api2/json/nodes/pve1/qemu/100/")
+					todisk = r.PostFormValue("todisk")
+				default:
+					t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			provider := &ProxmoxProvider{
+				APIURL:      server.URL + "/api2/json",
+				Node:        "pve1",
+				VMID:        "100",
+				Token:       "user@pam!token=secret",
+				Insecure:    true,
+				SleepAction: tt.sleepAction,
+			}
+
+			err := provider.Sleep(context.Background())
 			if tt.expectError && err == nil {
 				t.Error("Expected error, got nil")
 			}
 			if !tt.expectError && err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
+			if calledEndpoint != tt.expectedEndpoint {
+				t.Errorf("Expected %q to be called, got %q", tt.expectedEndpoint, calledEndpoint)
+			}
+			if todisk != tt.expectedTodisk {
+				t.Errorf("Expected todisk=%q, got %q", tt.expectedTodisk, todisk)
+			}
 		})
 	}
 }
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsConfig returns the TLS configuration for API requests. It verifies the
+// server against CAFile if set, otherwise against the system roots, and
+// additionally requires one of the pinned Fingerprints if any are set. A pinned
+// certificate is accepted even if it is self-signed.
+func (p *ProxmoxProvider) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: p.Insecure}
+
+	if p.CAFile != "" {
+		pool, err := LoadCAFile(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(p.Fingerprints) > 0 {
+		pins := make([][]byte, len(p.Fingerprints))
+		for i, fingerprint := range p.Fingerprints {
+			pin, err := ParseFingerprint(fingerprint)
+			if err != nil {
+				return nil, err
+			}
+			pins[i] = pin
+		}
+		// The pin replaces chain verification unless a CA is given too.
+		cfg.InsecureSkipVerify = p.CAFile == ""
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyFingerprint(cs, pins)
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyFingerprint checks that the server's certificate matches one of pins.
+func verifyFingerprint(cs tls.ConnectionState, pins [][]byte) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server sent no certificate")
+	}
+
+	sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+	for _, pin := range pins {
+		if bytes.Equal(pin, sum[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("server certificate fingerprint %s does not match any pinned fingerprint", formatFingerprint(sum[:]))
+}
+
+// LoadCAFile reads a PEM file of CA certificates, such as the Proxmox cluster
+// CA from /etc/pve/pve-root-ca.pem.
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// ParseFingerprint parses a SHA-256 certificate fingerprint as shown by the
+// Proxmox UI, e.g. "AB:CD:...". Colons are optional and case is ignored.
+func ParseFingerprint(fingerprint string) ([]byte, error) {
+	sum, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+	return sum, nil
+}
+
+// formatFingerprint formats a SHA-256 fingerprint the way Proxmox shows it.
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxmoxProviderTLSVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"status":"running"}}`))
+	}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	fingerprint := formatFingerprint(sum[:])
+
+	caFile := filepath.Join(t.TempDir(), "pve-root-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	otherFingerprint := strings.Repeat("AB:", 31) + "AB"
+
+	tests := []struct {
+		name         string
+		caFile       string
+		fingerprints []string
+		expectError  string
+	}{
+		{name: "System Roots Reject Self-Signed", expectError: "certificate"},
+		{name: "CA File", caFile: caFile},
+		{name: "Pinned Fingerprint", fingerprints: []string{fingerprint}},
+		{name: "Pinned Fingerprint Lowercase Without Colons", fingerprints: []string{strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))}},
+		{name: "One Of Several Fingerprints", fingerprints: []string{otherFingerprint, fingerprint}},
+		{name: "Fingerprint Mismatch", fingerprints: []string{otherFingerprint}, expectError: "does not match any pinned fingerprint"},
+		{name: "CA File And Fingerprint Mismatch", caFile: caFile, fingerprints: []string{otherFingerprint}, expectError: "does not match any pinned fingerprint"},
+		{name: "Missing CA File", caFile: filepath.Join(t.TempDir(), "missing.pem"), expectError: "failed to read CA file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &ProxmoxProvider{
+				APIURL:       server.URL + "/api2/json",
+				Node:         "pve1",
+				VMID:         "100",
+				Token:        "user@pam!token=secret",
+				CAFile:       tt.caFile,
+				Fingerprints: tt.fingerprints,
+			}
+
+			_, err := provider.Wake(context.Background())
+			if tt.expectError == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectError) {
+				t.Fatalf("Expected error containing %q, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	valid := strings.Repeat("0F:", 31) + "0F"
+	tests := []struct {
+		name        string
+		fingerprint string
+		expectError bool
+	}{
+		{name: "Proxmox Format", fingerprint: valid},
+		{name: "Without Colons", fingerprint: strings.ReplaceAll(valid, ":", "")},
+		{name: "Too Short", fingerprint: "0F:0F", expectError: true},
+		{name: "SHA-1 Length", fingerprint: strings.Repeat("0F:", 19) + "0F", expectError: true},
+		{name: "Not Hex", fingerprint: strings.Repeat("ZZ:", 31) + "ZZ", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFingerprint(tt.fingerprint)
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -1,19 +1,95 @@
 package provider
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // WOLProvider is a WakeupProvider that sends a Wake-on-LAN magic packet.
+// Wake-on-LAN has no way to power a machine down, so sleeping runs SleepCommand,
+// e.g. "ssh user@host sudo systemctl suspend".
 type WOLProvider struct {
 	TargetMAC         string
 	TargetBroadcastIP string
+	SleepCommand      string
+
+	// SecureOn is the optional SecureOn password of the NIC, see ParseSecureOn.
+	SecureOn string
+
+	// Interface picks the egress interface by name or local IPv4 address, or
+	// is WOLAllInterfaces. By default, the OS picks it.
+	Interface string
+	Port      int // UDP port, usually 9 (discard) or 7 (echo); defaults to 9
+
+	// Transport is WOLTransportUDP (the default) or WOLTransportEthernet,
+	// which sends raw frames on Interface and falls back to UDP.
+	Transport string
+
+	// BurstCount packets are sent BurstInterval apart on every wake, in case
+	// one is lost, e.g. while a switch port is still negotiating.
+	BurstCount    int           // defaults to 1
+	BurstInterval time.Duration // defaults to 100ms
+
+	// Relay is the host:port of a mop relay agent that sends the packets on
+	// the target's network instead, reached over RelayProtocol and signed
+	// with RelaySecret. See RelayAgent.
+	Relay         string
+	RelayProtocol string // RelayProtocolTCP (the default) or RelayProtocolUDP
+	RelaySecret   string
+
+	sendFrame func(ifindex int, frame []byte) error // sendRawFrame, unless replaced in tests
 }
 
-func (w *WOLProvider) Wake() error {
-	return w.sendWOLPacket()
+const defaultWOLBurstInterval = 100 * time.Millisecond
+
+// Wake sends a burst of magic packets. Wake-on-LAN gets no reply, so the
+// target is always reported as started.
+func (w *WOLProvider) Wake(ctx context.Context) (WakeResult, error) {
+	interval := w.BurstInterval
+	if interval == 0 {
+		interval = defaultWOLBurstInterval
+	}
+
+	for i := range max(w.BurstCount, 1) {
+		if i > 0 {
+			// The packets sent so far may already wake the target
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return WakeResult{Status: WakeStarted}, nil
+			}
+		}
+		if err := w.sendWOLPacket(ctx); err != nil {
+			return WakeResult{Status: WakeFailed}, err
+		}
+	}
+	return WakeResult{Status: WakeStarted}, nil
+}
+
+// Sleep runs the configured sleep command. The command is split on whitespace
+// and executed directly, without a shell.
+func (w *WOLProvider) Sleep(ctx context.Context) error {
+	args := strings.Fields(w.SleepCommand)
+	if len(args) == 0 {
+		return fmt.Errorf("no sleep command configured for MAC %s", w.TargetMAC)
+	}
+
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sleep command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	log.Printf("Ran sleep command for MAC %s: %s", w.TargetMAC, w.SleepCommand)
+	return nil
 }
 
 // createMagicPacket creates a Wake-on-LAN magic packet from a MAC address string.
@@ -23,8 +99,16 @@ func (w *WOLProvider) createMagicPacket() ([]byte, error) {
 		return nil, fmt.Errorf("invalid MAC address format: %w", err)
 	}
 
-	// Magic packet is 6 bytes of 0xFF followed by 16 repetitions of the MAC address
-	packet := make([]byte, 6, 102)
+	var password []byte
+	if w.SecureOn != "" {
+		if password, err = ParseSecureOn(w.SecureOn); err != nil {
+			return nil, err
+		}
+	}
+
+	// Magic packet is 6 bytes of 0xFF followed by 16 repetitions of the MAC address,
+	// and the SecureOn password if the NIC requires one
+	packet := make([]byte, 6, 102+len(password))
 	for i := 0; i < 6; i++ {
 		packet[i] = 0xFF
 	}
@@ -32,22 +116,108 @@ func (w *WOLProvider) createMagicPacket() ([]byte, error) {
 	for i := 0; i < 16; i++ {
 		packet = append(packet, hwAddr...)
 	}
+	packet = append(packet, password...)
 
 	return packet, nil
 }
 
-// sendWOLPacket constructs and sends the Wake-on-LAN packet.
-func (w *WOLProvider) sendWOLPacket() error {
+// ParseSecureOn decodes a SecureOn password, either 6 bytes written like a MAC
+// address ("01:23:45:67:89:AB") or 4 bytes written like a MAC address or an
+// IPv4 address ("192.168.1.1"), as accepted by ether-wake.
+func ParseSecureOn(password string) ([]byte, error) {
+	if strings.Contains(password, ".") {
+		addr, err := netip.ParseAddr(password)
+		if err != nil || !addr.Is4() {
+			return nil, fmt.Errorf("invalid SecureOn password %q: expected 4 bytes like 192.168.1.1", password)
+		}
+		b := addr.As4()
+		return b[:], nil
+	}
+
+	parts := strings.Split(strings.ReplaceAll(password, "-", ":"), ":")
+	if len(parts) != 4 && len(parts) != 6 {
+		return nil, fmt.Errorf("invalid SecureOn password %q: expected 4 or 6 bytes like 01:23:45:67:89:AB", password)
+	}
+	b := make([]byte, 0, len(parts))
+	for _, part := range parts {
+		v, err := hex.DecodeString(part)
+		if err != nil || len(v) != 1 {
+			return nil, fmt.Errorf("invalid SecureOn password %q: %q is not a hex byte", password, part)
+		}
+		b = append(b, v[0])
+	}
+	return b, nil
+}
+
+// sendWOLPacket constructs and sends the Wake-on-LAN packet, over Transport.
+func (w *WOLProvider) sendWOLPacket(ctx context.Context) error {
 	magicPacket, err := w.createMagicPacket()
 	if err != nil {
 		return err
 	}
+	if w.Relay != "" {
+		return w.sendRelay(ctx)
+	}
+
+	var ifaces []wolInterface
+	if w.Interface != "" {
+		if ifaces, err = listInterfaces(); err != nil {
+			return err
+		}
+	}
 
-	// The destination for a WOL packet is typically port 9
-	addr := net.JoinHostPort(w.TargetBroadcastIP, "9")
+	if w.Transport != WOLTransportEthernet {
+		return w.sendUDP(ctx, ifaces, magicPacket)
+	}
+	rawErr := w.sendEthernet(ifaces, magicPacket)
+	if rawErr == nil {
+		return nil
+	}
+	log.Printf("Failed to send raw Ethernet Wake-on-LAN packet for MAC %s, falling back to UDP: %v", w.TargetMAC, rawErr)
+	if err := w.sendUDP(ctx, ifaces, magicPacket); err != nil {
+		return fmt.Errorf("%w, and the UDP fallback failed: %w", rawErr, err)
+	}
+	return nil
+}
 
-	// We don't need a specific local port, so we can use ":0"
-	conn, err := net.Dial("udp", addr)
+// sendUDP sends the magic packet as a UDP datagram to each destination.
+func (w *WOLProvider) sendUDP(ctx context.Context, ifaces []wolInterface, magicPacket []byte) error {
+	dests, err := w.destinations(ifaces)
+	if err != nil {
+		return fmt.Errorf("failed to choose an interface for WOL: %w", err)
+	}
+
+	// With several interfaces, reaching the target through one of them is enough
+	var errs []error
+	for _, dest := range dests {
+		if err := w.sendTo(ctx, dest, magicPacket); err != nil {
+			errs = append(errs, err)
+			if len(dests) > 1 {
+				log.Printf("Failed to send Wake-on-LAN packet via %s: %v", dest.iface, err)
+			}
+		}
+	}
+	if len(errs) == len(dests) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// sendTo sends the magic packet to a single destination.
+func (w *WOLProvider) sendTo(ctx context.Context, dest wolDestination, magicPacket []byte) error {
+	port := w.Port
+	if port == 0 {
+		port = defaultWOLPort
+	}
+	addr := net.JoinHostPort(dest.host, strconv.Itoa(port))
+
+	// We don't need a specific local port, only the source address if an
+	// interface was chosen
+	var dialer net.Dialer
+	if dest.source.IsValid() {
+		dialer.LocalAddr = &net.UDPAddr{IP: dest.source.AsSlice()}
+	}
+	conn, err := dialer.DialContext(ctx, "udp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to dial UDP for WOL: %w", err)
 	}
@@ -55,9 +225,13 @@ func (w *WOLProvider) sendWOLPacket() error {
 
 	bytesWritten, err := conn.Write(magicPacket)
 	if err != nil {
-		return fmt.Errorf("failed to write magic packet: %w", err)
+		return fmt.Errorf("failed to write magic packet to %s: %w", addr, err)
 	}
 
-	log.Printf("Sent %d byte Wake-on-LAN packet to %s for MAC %s", bytesWritten, w.TargetBroadcastIP, w.TargetMAC)
+	if dest.iface != "" {
+		log.Printf("Sent %d byte Wake-on-LAN packet to %s via %s for MAC %s", bytesWritten, addr, dest.iface, w.TargetMAC)
+	} else {
+		log.Printf("Sent %d byte Wake-on-LAN packet to %s for MAC %s", bytesWritten, addr, w.TargetMAC)
+	}
 	return nil
 }
@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+)
+
+// Transports of a WOLProvider.
+const (
+	WOLTransportUDP      = "udp"
+	WOLTransportEthernet = "ethernet"
+)
+
+// etherTypeWOL is the EtherType of Wake-on-LAN frames.
+const etherTypeWOL = 0x0842
+
+// errRawPermission is returned when the process may not open raw sockets.
+var errRawPermission = errors.New("raw Ethernet needs CAP_NET_RAW, run mop as root or grant it the capability (docker run --cap-add NET_RAW)")
+
+var broadcastMAC = net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// ethernetFrame wraps the magic packet in a broadcast Ethernet frame with
+// EtherType 0x0842.
+func ethernetFrame(src net.HardwareAddr, magicPacket []byte) []byte {
+	frame := make([]byte, 0, 14+len(magicPacket))
+	frame = append(frame, broadcastMAC...)
+	frame = append(frame, src...)
+	frame = binary.BigEndian.AppendUint16(frame, etherTypeWOL)
+	return append(frame, magicPacket...)
+}
+
+// ethernetInterfaces returns the interfaces to send raw frames on: the one
+// named by Interface or holding its address, or with WOLAllInterfaces every
+// Ethernet interface that is up.
+func (w *WOLProvider) ethernetInterfaces(ifaces []wolInterface) ([]wolInterface, error) {
+	if w.Interface == "" {
+		return nil, fmt.Errorf("raw Ethernet needs an interface")
+	}
+	source, _ := netip.ParseAddr(w.Interface)
+
+	var result []wolInterface
+	for _, iface := range ifaces {
+		if w.Interface == WOLAllInterfaces {
+			if iface.flags&net.FlagUp != 0 && iface.flags&net.FlagLoopback == 0 && len(iface.hwAddr) == 6 {
+				result = append(result, iface)
+			}
+			continue
+		}
+
+		if source.IsValid() {
+			for _, prefix := range iface.addrs {
+				if prefix.Addr() == source {
+					result = append(result, iface)
+				}
+			}
+		} else if iface.name == w.Interface {
+			result = append(result, iface)
+		}
+	}
+
+	if len(result) == 0 {
+		if w.Interface == WOLAllInterfaces {
+			return nil, fmt.Errorf("no Ethernet interface is up")
+		}
+		return nil, fmt.Errorf("interface %s not found", w.Interface)
+	}
+	if iface := result[0]; w.Interface != WOLAllInterfaces {
+		if iface.flags&net.FlagUp == 0 {
+			return nil, fmt.Errorf("interface %s is down", iface.name)
+		}
+		if len(iface.hwAddr) != 6 {
+			return nil, fmt.Errorf("interface %s is not an Ethernet interface", iface.name)
+		}
+	}
+	return result, nil
+}
+
+// sendEthernet sends the magic packet as a raw Ethernet frame on each of the
+// chosen interfaces. Like over UDP, sending it on one of them is enough.
+func (w *WOLProvider) sendEthernet(ifaces []wolInterface, magicPacket []byte) error {
+	targets, err := w.ethernetInterfaces(ifaces)
+	if err != nil {
+		return err
+	}
+
+	send := w.sendFrame
+	if send == nil {
+		send = sendRawFrame
+	}
+
+	var errs []error
+	for _, iface := range targets {
+		frame := ethernetFrame(iface.hwAddr, magicPacket)
+		if err := send(iface.index, frame); err != nil {
+			// Without the capability, every other interface fails the same way
+			if errors.Is(err, errRawPermission) {
+				return err
+			}
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.name, err))
+			continue
+		}
+		log.Printf("Sent %d byte Wake-on-LAN frame on %s for MAC %s", len(frame), iface.name, w.TargetMAC)
+	}
+	if len(errs) == len(targets) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
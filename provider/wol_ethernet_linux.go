@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// sendRawFrame sends a complete Ethernet frame on the interface with the given
+// index through an AF_PACKET socket.
+func sendRawFrame(ifindex int, frame []byte) error {
+	// Protocol 0 sends without receiving anything on the socket
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			return fmt.Errorf("%w: %v", errRawPermission, err)
+		}
+		return fmt.Errorf("failed to open raw socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	// sockaddr_ll expects the protocol in network byte order
+	var protocol [2]byte
+	binary.BigEndian.PutUint16(protocol[:], etherTypeWOL)
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: binary.NativeEndian.Uint16(protocol[:]),
+		Ifindex:  ifindex,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], broadcastMAC)
+
+	if err := syscall.Sendto(fd, frame, 0, addr); err != nil {
+		return fmt.Errorf("failed to send raw frame: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package provider
+
+import "errors"
+
+// sendRawFrame is only implemented on Linux; elsewhere the UDP fallback is used.
+func sendRawFrame(ifindex int, frame []byte) error {
+	return errors.New("raw Ethernet is only supported on Linux")
+}
@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEthernetFrame(t *testing.T) {
+	p := &WOLProvider{TargetMAC: "AA:BB:CC:DD:EE:FF"}
+	packet, err := p.createMagicPacket()
+	if err != nil {
+		t.Fatalf("createMagicPacket failed: %v", err)
+	}
+
+	src := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	frame := ethernetFrame(src, packet)
+
+	if len(frame) != 14+102 {
+		t.Fatalf("Expected frame length 116, got %d", len(frame))
+	}
+	if !bytes.Equal(frame[0:6], broadcastMAC) {
+		t.Errorf("Expected broadcast destination, got % X", frame[0:6])
+	}
+	if !bytes.Equal(frame[6:12], src) {
+		t.Errorf("Expected source %s, got % X", src, frame[6:12])
+	}
+	if frame[12] != 0x08 || frame[13] != 0x42 {
+		t.Errorf("Expected EtherType 0x0842, got %02X%02X", frame[12], frame[13])
+	}
+	if !bytes.Equal(frame[14:], packet) {
+		t.Error("Expected the magic packet as payload")
+	}
+}
+
+func TestEthernetInterfaces(t *testing.T) {
+	hw := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	ifaces := []wolInterface{
+		{name: "lo", index: 1, flags: net.FlagUp | net.FlagLoopback, addrs: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}},
+		{name: "eth0", index: 2, flags: net.FlagUp | net.FlagBroadcast, hwAddr: hw, addrs: []netip.Prefix{netip.MustParsePrefix("192.168.1.10/24")}},
+		{name: "vmbr1", index: 3, flags: net.FlagUp | net.FlagBroadcast, hwAddr: hw},
+		{name: "eth2", index: 4, flags: net.FlagBroadcast, hwAddr: hw},
+		{name: "wg0", index: 5, flags: net.FlagUp, addrs: []netip.Prefix{netip.MustParsePrefix("10.8.0.2/32")}},
+	}
+
+	tests := []struct {
+		name        string
+		iface       string
+		expected    []string
+		expectError bool
+	}{
+		{name: "By Name Without Address", iface: "vmbr1", expected: []string{"vmbr1"}},
+		{name: "By Source Address", iface: "192.168.1.10", expected: []string{"eth0"}},
+		{name: "All Interfaces", iface: WOLAllInterfaces, expected: []string{"eth0", "vmbr1"}},
+		{name: "No Interface", iface: "", expectError: true},
+		{name: "Interface Down", iface: "eth2", expectError: true},
+		{name: "Not Ethernet", iface: "wg0", expectError: true},
+		{name: "Unknown Interface", iface: "eth9", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &WOLProvider{TargetMAC: "AA:BB:CC:DD:EE:FF", Interface: tt.iface, Transport: WOLTransportEthernet}
+			result, err := w.ethernetInterfaces(ifaces)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var names []string
+			for _, iface := range result {
+				names = append(names, iface.name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected interfaces %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestEthernetFallsBackToUDP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+
+	// The loopback interface has no Ethernet address, so pick it by address
+	// and pretend the raw socket was refused.
+	sent := 0
+	w := &WOLProvider{
+		TargetMAC:         "AA:BB:CC:DD:EE:FF",
+		TargetBroadcastIP: "127.0.0.1",
+		Interface:         "127.0.0.1",
+		Port:              conn.LocalAddr().(*net.UDPAddr).Port,
+		Transport:         WOLTransportEthernet,
+		sendFrame: func(ifindex int, frame []byte) error {
+			sent++
+			return fmt.Errorf("%w: operation not permitted", errRawPermission)
+		},
+	}
+	ifaces := []wolInterface{{name: lo.Name, index: lo.Index, flags: net.FlagUp, hwAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, addrs: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}}}
+
+	if err := w.sendEthernet(ifaces, []byte("magic")); !errors.Is(err, errRawPermission) {
+		t.Errorf("Expected the CAP_NET_RAW error, got %v", err)
+	}
+	if sent != 1 {
+		t.Errorf("Expected 1 raw frame attempt, got %d", sent)
+	}
+
+	// Wake lists the real interfaces, where lo has no Ethernet address, and
+	// still reaches the target over UDP.
+	if _, err := w.Wake(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 200)
+	if n, _, err := conn.ReadFromUDP(buf); err != nil || n != 102 {
+		t.Errorf("Expected a 102 byte magic packet over UDP, got %d bytes, %v", n, err)
+	}
+}
+
+func TestEthernetFallbackErrorNamesBoth(t *testing.T) {
+	w := &WOLProvider{
+		TargetMAC:         "AA:BB:CC:DD:EE:FF",
+		TargetBroadcastIP: "255.255.255.255",
+		Interface:         "192.0.2.1", // not a local address
+		Transport:         WOLTransportEthernet,
+	}
+	_, err := w.Wake(context.Background())
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if want := "UDP fallback failed"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected error to contain %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// WOLAllInterfaces as a WOLProvider's Interface sends the magic packet on
+// every interface that is up, to each interface's directed broadcast address.
+const WOLAllInterfaces = "all"
+
+const (
+	defaultWOLPort   = 9
+	limitedBroadcast = "255.255.255.255"
+)
+
+// wolInterface is a local network interface and its IPv4 addresses.
+type wolInterface struct {
+	name   string
+	index  int
+	flags  net.Flags
+	hwAddr net.HardwareAddr
+	addrs  []netip.Prefix
+}
+
+// wolDestination is where a single magic packet is sent.
+type wolDestination struct {
+	iface  string     // empty if the OS picks the interface
+	source netip.Addr // invalid if the OS picks the source address
+	host   string
+}
+
+// destinations returns where to send the magic packet. Without an Interface,
+// it goes to TargetBroadcastIP and the OS picks the egress interface. With
+// one, it is sent from each of the chosen interfaces' IPv4 addresses, to
+// TargetBroadcastIP if one other than 255.255.255.255 is set and to the
+// address's directed broadcast otherwise, since the limited broadcast is
+// routed like any other address and may leave through the wrong interface.
+func (w *WOLProvider) destinations(ifaces []wolInterface) ([]wolDestination, error) {
+	if w.Interface == "" {
+		return []wolDestination{{host: w.TargetBroadcastIP}}, nil
+	}
+
+	// Interface is an interface name, a local address or WOLAllInterfaces
+	source, _ := netip.ParseAddr(w.Interface)
+	directed := w.TargetBroadcastIP == "" || w.TargetBroadcastIP == limitedBroadcast
+
+	var dests []wolDestination
+	for _, iface := range ifaces {
+		if w.Interface == WOLAllInterfaces {
+			if iface.flags&net.FlagUp == 0 || iface.flags&net.FlagBroadcast == 0 || iface.flags&net.FlagLoopback != 0 {
+				continue
+			}
+		} else if iface.name != w.Interface && !source.IsValid() {
+			continue
+		}
+
+		for _, prefix := range iface.addrs {
+			if source.IsValid() && prefix.Addr() != source {
+				continue
+			}
+			if iface.flags&net.FlagUp == 0 {
+				return nil, fmt.Errorf("interface %s is down", iface.name)
+			}
+
+			host := w.TargetBroadcastIP
+			if directed {
+				host = directedBroadcast(prefix).String()
+			}
+			dests = append(dests, wolDestination{iface: iface.name, source: prefix.Addr(), host: host})
+		}
+	}
+
+	if len(dests) == 0 {
+		switch {
+		case w.Interface == WOLAllInterfaces:
+			return nil, fmt.Errorf("no interface is up with an IPv4 broadcast address")
+		case source.IsValid():
+			return nil, fmt.Errorf("no interface has the address %s", source)
+		default:
+			return nil, fmt.Errorf("interface %s not found or has no IPv4 address", w.Interface)
+		}
+	}
+	return dests, nil
+}
+
+// directedBroadcast returns the broadcast address of an IPv4 subnet, e.g.
+// 192.168.1.255 for 192.168.1.10/24. Point-to-point and host prefixes have
+// no broadcast address of their own, so the limited broadcast is used.
+func directedBroadcast(prefix netip.Prefix) netip.Addr {
+	if prefix.Bits() >= 31 {
+		return netip.MustParseAddr(limitedBroadcast)
+	}
+
+	addr := prefix.Addr().As4()
+	for i := prefix.Bits(); i < 32; i++ {
+		addr[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(addr)
+}
+
+// listInterfaces returns the local interfaces with their IPv4 addresses.
+func listInterfaces() ([]wolInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	var result []wolInterface
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of interface %s: %w", iface.Name, err)
+		}
+
+		wi := wolInterface{name: iface.Name, index: iface.Index, flags: iface.Flags, hwAddr: iface.HardwareAddr}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			addr, _ := netip.AddrFromSlice(ipNet.IP.To4())
+			ones, bits := ipNet.Mask.Size()
+			wi.addrs = append(wi.addrs, netip.PrefixFrom(addr, ones-(bits-32)))
+		}
+		result = append(result, wi)
+	}
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWOLDestinations(t *testing.T) {
+	ifaces := []wolInterface{
+		{name: "lo", flags: net.FlagUp | net.FlagLoopback, addrs: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/8")}},
+		{name: "eth0", flags: net.FlagUp | net.FlagBroadcast, addrs: []netip.Prefix{netip.MustParsePrefix("192.168.1.10/24")}},
+		{name: "eth1", flags: net.FlagUp | net.FlagBroadcast, addrs: []netip.Prefix{
+			netip.MustParsePrefix("10.0.20.5/16"),
+			netip.MustParsePrefix("172.16.0.1/12"),
+		}},
+		{name: "eth2", flags: net.FlagBroadcast, addrs: []netip.Prefix{netip.MustParsePrefix("192.168.2.10/24")}},
+		{name: "wg0", flags: net.FlagUp, addrs: []netip.Prefix{netip.MustParsePrefix("10.8.0.2/32")}},
+	}
+
+	tests := []struct {
+		name        string
+		iface       string
+		broadcastIP string
+		expected    []wolDestination
+		expectError bool
+	}{
+		{
+			name:        "OS Picks Interface",
+			broadcastIP: "255.255.255.255",
+			expected:    []wolDestination{{host: "255.255.255.255"}},
+		},
+		{
+			name:        "By Name",
+			iface:       "eth0",
+			broadcastIP: "255.255.255.255",
+			expected:    []wolDestination{{iface: "eth0", source: netip.MustParseAddr("192.168.1.10"), host: "192.168.1.255"}},
+		},
+		{
+			name:        "By Source Address",
+			iface:       "10.0.20.5",
+			broadcastIP: "255.255.255.255",
+			expected:    []wolDestination{{iface: "eth1", source: netip.MustParseAddr("10.0.20.5"), host: "10.0.255.255"}},
+		},
+		{
+			name:        "Explicit Broadcast Address",
+			iface:       "eth0",
+			broadcastIP: "192.168.1.127",
+			expected:    []wolDestination{{iface: "eth0", source: netip.MustParseAddr("192.168.1.10"), host: "192.168.1.127"}},
+		},
+		{
+			name:  "Point To Point",
+			iface: "wg0",
+			expected: []wolDestination{
+				{iface: "wg0", source: netip.MustParseAddr("10.8.0.2"), host: "255.255.255.255"},
+			},
+		},
+		{
+			name:        "All Interfaces",
+			iface:       WOLAllInterfaces,
+			broadcastIP: "255.255.255.255",
+			expected: []wolDestination{
+				{iface: "eth0", source: netip.MustParseAddr("192.168.1.10"), host: "192.168.1.255"},
+				{iface: "eth1", source: netip.MustParseAddr("10.0.20.5"), host: "10.0.255.255"},
+				{iface: "eth1", source: netip.MustParseAddr("172.16.0.1"), host: "172.31.255.255"},
+			},
+		},
+		{name: "Unknown Interface", iface: "eth9", expectError: true},
+		{name: "Interface Down", iface: "eth2", expectError: true},
+		{name: "Unknown Source Address", iface: "192.168.1.11", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &WOLProvider{TargetMAC: "AA:BB:CC:DD:EE:FF", TargetBroadcastIP: tt.broadcastIP, Interface: tt.iface}
+			dests, err := w.destinations(ifaces)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got %+v", dests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(dests) != len(tt.expected) {
+				t.Fatalf("Expected %d destinations, got %+v", len(tt.expected), dests)
+			}
+			for i := range tt.expected {
+				if dests[i] != tt.expected[i] {
+					t.Errorf("Expected destination %d to be %+v, got %+v", i, tt.expected[i], dests[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWOLSendsFromSourceAddressToPort(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	w := &WOLProvider{
+		TargetMAC:         "AA:BB:CC:DD:EE:FF",
+		TargetBroadcastIP: "127.0.0.1",
+		Interface:         "127.0.0.1",
+		Port:              conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	if _, err := w.Wake(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 200)
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("Expected a magic packet: %v", err)
+	}
+	if n != 102 {
+		t.Errorf("Expected 102 bytes, got %d", n)
+	}
+	if !from.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Expected the packet from 127.0.0.1, got %s", from.IP)
+	}
+}
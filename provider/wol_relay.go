@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Protocols a WOLProvider can reach its relay agent with.
+const (
+	RelayProtocolTCP = "tcp"
+	RelayProtocolUDP = "udp"
+)
+
+const (
+	relayTimeout = 5 * time.Second // per relay request, including the reply
+
+	// relayMaxSkew is how far the clocks of mop and its relay agent may
+	// drift apart. Requests older than this are rejected as replays.
+	relayMaxSkew = 30 * time.Second
+
+	relaySignaturePrefix = "mop-wol-relay-v1"
+	relayMaxMessageSize  = 4096
+)
+
+// relayRequest asks a relay agent to send a magic packet to MAC. It is sent
+// as a single line of JSON, or a single datagram over UDP.
+type relayRequest struct {
+	MAC       string `json:"mac"`
+	SecureOn  string `json:"secureon,omitempty"`
+	Time      int64  `json:"time"`  // Unix seconds
+	Nonce     string `json:"nonce"` // random, rejected if seen before
+	Signature string `json:"sig"`   // hex HMAC-SHA256, see sign
+}
+
+// relayResponse is the agent's answer to a relayRequest.
+type relayResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// sign returns the request's signature with secret. Every field but the
+// signature itself is covered, each on its own line.
+func (r *relayRequest) sign(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d\n%s", relaySignaturePrefix, r.MAC, r.SecureOn, r.Time, r.Nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendRelay asks the relay agent at Relay to send the magic packet on its
+// network, and waits for its answer.
+func (w *WOLProvider) sendRelay(ctx context.Context) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate relay nonce: %w", err)
+	}
+	req := relayRequest{MAC: w.TargetMAC, SecureOn: w.SecureOn, Time: time.Now().Unix(), Nonce: hex.EncodeToString(nonce)}
+	req.Signature = req.sign(w.RelaySecret)
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	protocol := w.RelayProtocol
+	if protocol == "" {
+		protocol = RelayProtocolTCP
+	}
+	ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, protocol, w.Relay)
+	if err != nil {
+		return fmt.Errorf("failed to reach WOL relay %s: %w", w.Relay, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send request to WOL relay %s: %w", w.Relay, err)
+	}
+	reply := make([]byte, relayMaxMessageSize)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return fmt.Errorf("no reply from WOL relay %s: %w", w.Relay, err)
+	}
+
+	var resp relayResponse
+	if err := json.Unmarshal(reply[:n], &resp); err != nil {
+		return fmt.Errorf("invalid reply from WOL relay %s: %w", w.Relay, err)
+	}
+	if !resp.OK {
+		return fmt.Errorf("WOL relay %s refused to wake MAC %s: %s", w.Relay, w.TargetMAC, resp.Error)
+	}
+
+	log.Printf("WOL relay %s sent the Wake-on-LAN packet for MAC %s", w.Relay, w.TargetMAC)
+	return nil
+}
+
+// RelayAgent sends magic packets on behalf of other mop instances, e.g. from
+// a DMZ into a LAN that directed broadcasts can't reach. Requests must be
+// signed with Secret and are answered over the connection they came in on.
+type RelayAgent struct {
+	Secret string
+
+	// AllowedMACs limits the MACs that may be woken. Empty allows any.
+	AllowedMACs []string
+
+	// Sender holds how packets are sent on this network, e.g. its Interface
+	// and Port. TargetMAC and SecureOn are taken from each request.
+	Sender WOLProvider
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonce -> when it may be forgotten
+}
+
+// ServeTCP answers requests on listener until it is closed.
+func (a *RelayAgent) ServeTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to accept relay connection on %s: %v", listener.Addr(), err)
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(relayTimeout))
+
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 0, relayMaxMessageSize), relayMaxMessageSize)
+			if !scanner.Scan() {
+				return
+			}
+			resp := a.handle(ctx, scanner.Bytes(), conn.RemoteAddr())
+			data, _ := json.Marshal(resp)
+			conn.Write(append(data, '\n'))
+		}()
+	}
+}
+
+// ServeUDP answers requests on conn until it is closed.
+func (a *RelayAgent) ServeUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, relayMaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Failed to read relay request on %s: %v", conn.LocalAddr(), err)
+			continue
+		}
+
+		resp := a.handle(ctx, buf[:n], addr)
+		data, _ := json.Marshal(resp)
+		conn.WriteTo(append(data, '\n'), addr)
+	}
+}
+
+// handle checks a request and sends the magic packet it asks for.
+func (a *RelayAgent) handle(ctx context.Context, data []byte, from net.Addr) relayResponse {
+	req, err := a.verify(data, time.Now())
+	if err != nil {
+		log.Printf("Rejected WOL relay request from %s: %v", from, err)
+		return relayResponse{Error: err.Error()}
+	}
+
+	sender := a.Sender
+	sender.TargetMAC = req.MAC
+	sender.SecureOn = req.SecureOn
+	sender.Relay = ""
+	if err := sender.sendWOLPacket(ctx); err != nil {
+		log.Printf("Failed to relay Wake-on-LAN packet for MAC %s from %s: %v", req.MAC, from, err)
+		return relayResponse{Error: err.Error()}
+	}
+
+	log.Printf("Relayed Wake-on-LAN packet for MAC %s from %s", req.MAC, from)
+	return relayResponse{OK: true}
+}
+
+// verify parses a request and checks its signature, age, nonce and MAC.
+func (a *RelayAgent) verify(data []byte, now time.Time) (relayRequest, error) {
+	var req relayRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("invalid request: %v", err)
+	}
+	if !hmac.Equal([]byte(req.Signature), []byte(req.sign(a.Secret))) {
+		return req, fmt.Errorf("invalid signature")
+	}
+
+	sent := time.Unix(req.Time, 0)
+	if sent.Before(now.Add(-relayMaxSkew)) || sent.After(now.Add(relayMaxSkew)) {
+		return req, fmt.Errorf("request time %s is more than %v off, check the clocks", sent.UTC().Format(time.RFC3339), relayMaxSkew)
+	}
+	if len(req.Nonce) < 16 {
+		return req, fmt.Errorf("nonce too short")
+	}
+
+	hwAddr, err := net.ParseMAC(req.MAC)
+	if err != nil {
+		return req, fmt.Errorf("invalid MAC address format: %v", err)
+	}
+	if !a.allowed(hwAddr) {
+		return req, fmt.Errorf("MAC %s is not allowed", hwAddr)
+	}
+
+	// A nonce can't be replayed once its request is too old anyway
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nonces == nil {
+		a.nonces = make(map[string]time.Time)
+	}
+	for nonce, expiry := range a.nonces {
+		if now.After(expiry) {
+			delete(a.nonces, nonce)
+		}
+	}
+	if _, seen := a.nonces[req.Nonce]; seen {
+		return req, fmt.Errorf("replayed request")
+	}
+	a.nonces[req.Nonce] = sent.Add(relayMaxSkew)
+	return req, nil
+}
+
+// allowed reports whether AllowedMACs permits waking hwAddr.
+func (a *RelayAgent) allowed(hwAddr net.HardwareAddr) bool {
+	if len(a.AllowedMACs) == 0 {
+		return true
+	}
+	for _, mac := range a.AllowedMACs {
+		if allowed, err := net.ParseMAC(mac); err == nil && allowed.String() == hwAddr.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRelaySecret = "correct horse battery staple"
+
+// startRelayAgent runs a relay agent on loopback that sends its packets to a
+// UDP listener, which is returned to read them from.
+func startRelayAgent(t *testing.T, protocol string, allowed ...string) (string, *net.UDPConn) {
+	t.Helper()
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { target.Close() })
+
+	agent := &RelayAgent{
+		Secret:      testRelaySecret,
+		AllowedMACs: allowed,
+		Sender:      WOLProvider{TargetBroadcastIP: "127.0.0.1", Port: target.LocalAddr().(*net.UDPAddr).Port},
+	}
+
+	var addr string
+	if protocol == RelayProtocolUDP {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go agent.ServeUDP(context.Background(), conn)
+		addr = conn.LocalAddr().String()
+	} else {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go agent.ServeTCP(context.Background(), listener)
+		addr = listener.Addr().String()
+	}
+	return addr, target
+}
+
+func TestWOLRelay(t *testing.T) {
+	for _, protocol := range []string{RelayProtocolTCP, RelayProtocolUDP} {
+		t.Run(protocol, func(t *testing.T) {
+			addr, target := startRelayAgent(t, protocol)
+			w := &WOLProvider{
+				TargetMAC:     "AA:BB:CC:DD:EE:FF",
+				SecureOn:      "01:23:45:67",
+				Relay:         addr,
+				RelayProtocol: protocol,
+				RelaySecret:   testRelaySecret,
+			}
+			if _, err := w.Wake(context.Background()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			target.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 200)
+			n, _, err := target.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("Expected the agent to send a magic packet: %v", err)
+			}
+			if n != 106 || buf[101] != 0xFF || buf[102] != 0x01 {
+				t.Errorf("Expected a 106 byte magic packet with the SecureOn password, got % X", buf[:n])
+			}
+		})
+	}
+}
+
+func TestWOLRelayRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		mac      string
+		secret   string
+		expected string
+	}{
+		{name: "Wrong Secret", mac: "AA:BB:CC:DD:EE:FF", secret: "wrong", expected: "invalid signature"},
+		{name: "MAC Not Allowed", mac: "11:22:33:44:55:66", secret: testRelaySecret, expected: "MAC 11:22:33:44:55:66 is not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := startRelayAgent(t, RelayProtocolTCP, "aa-bb-cc-dd-ee-ff")
+			w := &WOLProvider{TargetMAC: tt.mac, Relay: addr, RelaySecret: tt.secret}
+			_, err := w.Wake(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestRelayAgentVerify(t *testing.T) {
+	agent := &RelayAgent{Secret: testRelaySecret}
+	now := time.Now()
+
+	request := func(sent time.Time, nonce string) []byte {
+		req := relayRequest{MAC: "AA:BB:CC:DD:EE:FF", Time: sent.Unix(), Nonce: nonce}
+		req.Signature = req.sign(testRelaySecret)
+		data, _ := json.Marshal(req)
+		return data
+	}
+
+	if _, err := agent.verify(request(now, "00112233445566778899aabbccddeeff"), now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := agent.verify(request(now, "00112233445566778899aabbccddeeff"), now); err == nil {
+		t.Error("Expected a replayed request to be rejected, got nil")
+	}
+	if _, err := agent.verify(request(now.Add(-time.Minute), "ffeeddccbbaa99887766554433221100"), now); err == nil {
+		t.Error("Expected an old request to be rejected, got nil")
+	}
+
+	// A tampered request no longer matches its signature
+	data := request(now, "0123456789abcdef0123456789abcdef")
+	data = []byte(strings.Replace(string(data), "AA:BB:CC:DD:EE:FF", "11:22:33:44:55:66", 1))
+	if _, err := agent.verify(data, now); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("Expected an invalid signature, got %v", err)
+	}
+
+	// Nonces are forgotten once their requests are too old to be replayed
+	agent.verify(request(now, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), now)
+	agent.verify(request(now.Add(time.Minute), "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"), now.Add(time.Minute))
+	if len(agent.nonces) != 1 {
+		t.Errorf("Expected expired nonces to be pruned, got %d", len(agent.nonces))
+	}
+}
@@ -1,7 +1,11 @@
 package provider
 
 import (
+	"bytes"
+	"context"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestMagicPacketCreation(t *testing.T) {
@@ -32,3 +36,117 @@ func TestMagicPacketCreation(t *testing.T) {
 		}
 	}
 }
+
+func TestMagicPacketSecureOn(t *testing.T) {
+	tests := []struct {
+		name     string
+		secureOn string
+		password []byte
+	}{
+		{name: "Six Bytes", secureOn: "01:23:45:67:89:ab", password: []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB}},
+		{name: "Six Bytes With Hyphens", secureOn: "01-23-45-67-89-AB", password: []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB}},
+		{name: "Four Bytes", secureOn: "DE:AD:BE:EF", password: []byte{0xDE, 0xAD, 0xBE, 0xEF}},
+		{name: "Four Bytes Dotted", secureOn: "192.168.1.1", password: []byte{192, 168, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &WOLProvider{TargetMAC: "AA:BB:CC:DD:EE:FF", SecureOn: tt.secureOn}
+			packet, err := p.createMagicPacket()
+			if err != nil {
+				t.Fatalf("createMagicPacket failed: %v", err)
+			}
+
+			if len(packet) != 102+len(tt.password) {
+				t.Fatalf("Expected packet length %d, got %d", 102+len(tt.password), len(packet))
+			}
+			// The password follows the last MAC repetition
+			if last := packet[96:102]; !bytes.Equal(last, []byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}) {
+				t.Errorf("Expected the MAC before the password, got % X", last)
+			}
+			if got := packet[102:]; !bytes.Equal(got, tt.password) {
+				t.Errorf("Expected password % X, got % X", tt.password, got)
+			}
+		})
+	}
+}
+
+func TestParseSecureOnErrors(t *testing.T) {
+	for _, password := range []string{"01:23:45", "01:23:45:67:89", "01:23:45:67:89:AB:CD", "01::45:67", "0123:45:67", "GG:23:45:67", "::1", "256.1.1.1"} {
+		if _, err := ParseSecureOn(password); err == nil {
+			t.Errorf("Expected error for %q, got nil", password)
+		}
+	}
+}
+
+func TestWOLBurst(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	w := &WOLProvider{
+		TargetMAC:         "AA:BB:CC:DD:EE:FF",
+		TargetBroadcastIP: "127.0.0.1",
+		Port:              conn.LocalAddr().(*net.UDPAddr).Port,
+		BurstCount:        3,
+		BurstInterval:     20 * time.Millisecond,
+	}
+	start := time.Now()
+	if _, err := w.Wake(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected the packets to be spaced 20ms apart, took %v", elapsed)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 200)
+	for i := range 3 {
+		if _, _, err := conn.ReadFromUDP(buf); err != nil {
+			t.Fatalf("Expected packet %d of the burst: %v", i+1, err)
+		}
+	}
+}
+
+func TestWOLBurstStopsOnCancel(t *testing.T) {
+	w := &WOLProvider{
+		TargetMAC:         "AA:BB:CC:DD:EE:FF",
+		TargetBroadcastIP: "127.0.0.1",
+		BurstCount:        5,
+		BurstInterval:     time.Hour,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result, err := w.Wake(ctx)
+	if err != nil || result.Status != WakeStarted {
+		t.Errorf("Expected the packet sent before the cancel to count as started, got %v, %v", result.Status, err)
+	}
+}
+
+func TestWOLSleepCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		expectError bool
+	}{
+		{name: "Command Succeeds", command: "true", expectError: false},
+		{name: "Command Fails", command: "false", expectError: true},
+		{name: "No Command", command: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &WOLProvider{TargetMAC: "AA:BB:CC:DD:EE:FF", SleepCommand: tt.command}
+			err := p.Sleep(context.Background())
+			if tt.expectError && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
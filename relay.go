@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"mop/provider"
+	"net"
+	"os/signal"
+	"syscall"
+)
+
+// runRelay runs mop as a relay agent: it listens for signed wake requests from
+// other mop instances on both TCP and UDP and sends the magic packets on the
+// local network, until SIGINT or SIGTERM.
+func runRelay() {
+	rc, err := loadRelayConfig()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	agent := &provider.RelayAgent{
+		Secret:      rc.Secret,
+		AllowedMACs: rc.AllowedMACs,
+		Sender: provider.WOLProvider{
+			TargetBroadcastIP: rc.BroadcastIP,
+			Interface:         rc.Interface,
+			Port:              rc.Port,
+			Transport:         rc.Transport,
+		},
+	}
+
+	listener, err := net.Listen("tcp", rc.Listen)
+	if err != nil {
+		log.Fatalf("Failed to start relay listener on %s: %v", rc.Listen, err)
+	}
+	conn, err := net.ListenPacket("udp", rc.Listen)
+	if err != nil {
+		log.Fatalf("Failed to start relay listener on %s: %v", rc.Listen, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go agent.ServeTCP(ctx, listener)
+	go agent.ServeUDP(ctx, conn)
+	if len(rc.AllowedMACs) == 0 {
+		log.Printf("mop relay agent listening on %s (TCP and UDP) for any MAC", rc.Listen)
+	} else {
+		log.Printf("mop relay agent listening on %s (TCP and UDP) for MACs %v", rc.Listen, rc.AllowedMACs)
+	}
+
+	<-ctx.Done()
+	log.Println("Shutting down relay agent.")
+	listener.Close()
+	conn.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"mop/probe"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// route is a listening port forwarding clients to a port on a machine.
+type route struct {
+	RouteConfig
+	machine *machine
+	probe   probe.Probe
+}
+
+// targetAddr returns the address clients of this route are forwarded to.
+func (r *route) targetAddr() string {
+	return net.JoinHostPort(r.machine.currentHost(), strconv.Itoa(r.TargetPort))
+}
+
+// newProbe builds the readiness probe described by a ProbeConfig.
+func newProbe(pc ProbeConfig) (probe.Probe, error) {
+	switch pc.Type {
+	case "", "tcp":
+		return &probe.TCPProbe{}, nil
+	case "ssh":
+		return &probe.SSHProbe{}, nil
+	case "http":
+		return &probe.HTTPProbe{
+			Path:           pc.Path,
+			ExpectedStatus: pc.Status,
+			TLS:            pc.TLS,
+			Insecure:       pc.Insecure,
+		}, nil
+	case "tls":
+		return &probe.TLSProbe{
+			ServerName: pc.ServerName,
+			Insecure:   pc.Insecure,
+		}, nil
+	case "expect":
+		expect, err := regexp.Compile(pc.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expect pattern: %w", err)
+		}
+		send, err := unescapeProbeSend(pc.Send)
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe payload: %w", err)
+		}
+		return &probe.ExpectProbe{Send: []byte(send), Expect: expect}, nil
+	default:
+		return nil, fmt.Errorf("unknown readiness probe: %s", pc.Type)
+	}
+}
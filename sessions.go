@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// sessionTracker keeps track of client connections being handled, so they can
+// be drained, and if necessary closed, on shutdown.
+type sessionTracker struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{conns: make(map[net.Conn]struct{})}
+}
+
+// Add registers a client connection. It must be called before the connection is handed off.
+func (s *sessionTracker) Add(conn net.Conn) {
+	s.wg.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = struct{}{}
+}
+
+// Done unregisters a client connection once it has been handled.
+func (s *sessionTracker) Done(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// Count returns the number of client connections being handled.
+func (s *sessionTracker) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Wait waits for all client connections to finish. It returns false if some
+// are still open after timeout.
+func (s *sessionTracker) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// CloseAll closes every client connection that is still open.
+func (s *sessionTracker) CloseAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSessionTrackerDrains(t *testing.T) {
+	s := newSessionTracker()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	s.Add(client)
+	if got := s.Count(); got != 1 {
+		t.Errorf("Expected 1 session, got %d", got)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.Done(client)
+	}()
+
+	if !s.Wait(time.Second) {
+		t.Fatal("Expected sessions to drain before the timeout")
+	}
+	if got := s.Count(); got != 0 {
+		t.Errorf("Expected 0 sessions, got %d", got)
+	}
+}
+
+func TestSessionTrackerTimeoutAndCloseAll(t *testing.T) {
+	s := newSessionTracker()
+	client, server := net.Pipe()
+	defer server.Close()
+
+	s.Add(client)
+	if s.Wait(20 * time.Millisecond) {
+		t.Fatal("Expected Wait to time out with an open session")
+	}
+
+	s.CloseAll()
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("Expected write to a closed session to fail")
+	}
+	s.Done(client)
+}
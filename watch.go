@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// maxWatchBuffer caps how much data a client may send while it waits for its
+// target. Beyond that, the watcher stops reading and can no longer notice the
+// client hanging up, but nothing is lost.
+const maxWatchBuffer = 64 * 1024
+
+// clientWatcher reads from a client connection while the client waits for its
+// target, so a client hanging up is noticed straight away. Data the client
+// sends in the meantime, e.g. an SSH client's banner, is kept so it can be
+// forwarded to the target once connected.
+//
+// EOF is not a hang up: the client may have only closed its sending side,
+// e.g. after sending a complete request, and still wait for the reply.
+type clientWatcher struct {
+	conn     net.Conn
+	done     chan struct{}
+	buf      []byte
+	closed   bool
+	eof      bool
+	stopping atomic.Bool
+}
+
+// watchClient starts watching conn and calls onClose if the client hangs up.
+func watchClient(conn net.Conn, onClose func()) *clientWatcher {
+	w := &clientWatcher{conn: conn, done: make(chan struct{})}
+	go w.run(onClose)
+	return w
+}
+
+func (w *clientWatcher) run(onClose func()) {
+	defer close(w.done)
+
+	chunk := make([]byte, 4096)
+	for len(w.buf) < maxWatchBuffer {
+		n, err := w.conn.Read(chunk)
+		w.buf = append(w.buf, chunk[:n]...)
+		if errors.Is(err, io.EOF) {
+			w.eof = true
+			return
+		}
+		if err != nil {
+			// Errors caused by Stop interrupting the read are not a hang up.
+			if !w.stopping.Load() {
+				w.closed = true
+				onClose()
+			}
+			return
+		}
+	}
+}
+
+// Stop stops watching the client. It returns the data the client sent while
+// being watched, whether the client hung up and whether it closed its sending
+// side, after which there is nothing more to read from it.
+func (w *clientWatcher) Stop() (early []byte, hungUp, eof bool) {
+	w.stopping.Store(true)
+	w.conn.SetReadDeadline(time.Now())
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.buf, w.closed, w.eof
+}
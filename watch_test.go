@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientWatcherDetectsHangUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer server.Close()
+
+	hungUp := make(chan struct{})
+	w := watchClient(server, func() { close(hungUp) })
+
+	// Closing without lingering resets the connection
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+
+	select {
+	case <-hungUp:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the hang up to be detected")
+	}
+
+	if _, closed, _ := w.Stop(); !closed {
+		t.Error("Expected Stop to report the client hung up")
+	}
+}
+
+func TestClientWatcherHalfClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	w := watchClient(server, func() { t.Error("Unexpected hang up") })
+
+	client.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	client.Close()
+
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the watcher to stop at EOF")
+	}
+
+	buffered, closed, eof := w.Stop()
+	if closed {
+		t.Error("Expected EOF not to be reported as a hang up")
+	}
+	if !eof {
+		t.Error("Expected Stop to report EOF")
+	}
+	if string(buffered) != "SSH-2.0-OpenSSH_9.6\r\n" {
+		t.Errorf("Expected buffered client data, got %q", buffered)
+	}
+}
+
+func TestClientWatcherStopKeepsConnectionUsable(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	w := watchClient(server, func() { t.Error("Unexpected hang up") })
+	client.Write([]byte("early"))
+
+	buffered, closed, eof := w.Stop()
+	if closed || eof {
+		t.Error("Expected client not to be reported as hung up or done sending")
+	}
+	if string(buffered) != "early" {
+		t.Errorf("Expected buffered client data, got %q", buffered)
+	}
+
+	// The connection must still be readable after the watcher's deadline is cleared.
+	go client.Write([]byte("later"))
+	buf := make([]byte, 5)
+	if _, err := server.Read(buf); err != nil || string(buf) != "later" {
+		t.Errorf("Expected to read after Stop, got %q, %v", buf, err)
+	}
+}
+
+func TestHandleClientHalfClose(t *testing.T) {
+	// The target answers once it has read the whole request
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				request, _ := io.ReadAll(conn)
+				conn.Write(append([]byte("reply to "), request...))
+			}()
+		}
+	}()
+
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer proxy.Close()
+
+	cfg := defaultConfig()
+	r, _ := newTestRoute(t, cfg, target.Addr().String())
+	go func() {
+		conn, err := proxy.Accept()
+		if err != nil {
+			return
+		}
+		handleClient(context.Background(), conn, cfg, r)
+	}()
+
+	client, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(reply) != "reply to request" {
+		t.Errorf("Expected the target's reply, got %q", reply)
+	}
+}